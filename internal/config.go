@@ -20,23 +20,66 @@ import (
 const (
 	envConfName     = "PGFGACONFIG"
 	defaultConfFile = "/etc/pgfga/config.yaml"
+	// defaultDirectory is the name of the directory configured in the (legacy) ldap chapter
+	defaultDirectory = "default"
 )
 
 type FgaGeneralConfig struct {
 	LogLevel zapcore.Level `yaml:"loglevel"`
 	RunDelay time.Duration `yaml:"run_delay"`
 	Debug    bool          `yaml:"debug"`
+	// AllowMassDeletion can only be set with --allow-mass-deletion
+	AllowMassDeletion bool `yaml:"-"`
 }
 
 type FgaUserConfig struct {
-	Auth     string    `yaml:"auth"`
-	BaseDN   string    `yaml:"ldapbasedn"`
-	Filter   string    `yaml:"ldapfilter"`
-	MemberOf []string  `yaml:"memberof"`
-	Options  []string  `yaml:"options"`
-	Expiry   time.Time `yaml:"expiry"`
-	Password string    `yaml:"password"`
-	State    pg.State  `yaml:"state"`
+	Auth        string   `yaml:"auth"`
+	Directory   string   `yaml:"directory"`
+	BaseDN      string   `yaml:"ldapbasedn"`
+	Filter      string   `yaml:"ldapfilter"`
+	MemberAttrs []string `yaml:"ldapmemberattributes"`
+	// GroupHierarchy mirrors nested ldap groups as NOLOGIN roles, instead of granting all users the base group
+	GroupHierarchy bool `yaml:"ldapgrouphierarchy"`
+	// UserFilter filters the users of a group in Active Directory in chain mode (where Filter applies to the group)
+	UserFilter string `yaml:"ldapuserfilter"`
+	// Include and Exclude select the users of an ldap group that get a role
+	Include FgaMemberFilter `yaml:"ldapinclude"`
+	Exclude FgaMemberFilter `yaml:"ldapexclude"`
+	// MemberOptions, MemberSettings, MemberConnectionLimit and MemberExpiry are set for all users of an ldap group
+	MemberOptions         []string          `yaml:"member_options"`
+	MemberSettings        map[string]string `yaml:"member_settings"`
+	MemberConnectionLimit *int              `yaml:"member_connection_limit"`
+	MemberExpiry          time.Time         `yaml:"member_expiry"`
+	MemberOf              []string          `yaml:"memberof"`
+	Options               []string          `yaml:"options"`
+	Expiry                time.Time         `yaml:"expiry"`
+	Password              string            `yaml:"password"`
+	State                 pg.State          `yaml:"state"`
+	HbaAddresses          []string          `yaml:"hba_addresses"`
+	HbaDatabases          []string          `yaml:"hba_databases"`
+	CertCN                string            `yaml:"cert_cn"`
+	Principal             string            `yaml:"principal"`
+	IdentMap              string            `yaml:"map"`
+}
+
+// FgaDeprovisionConfig defines what happens with users that are no longer a member of any ldap group
+type FgaDeprovisionConfig struct {
+	// Action can be revoke (default), nologin or drop
+	Action      string        `yaml:"action"`
+	GracePeriod time.Duration `yaml:"grace_period"`
+}
+
+func (dc FgaDeprovisionConfig) validate() (err error) {
+	switch dc.Action {
+	case "", deprovisionRevoke, deprovisionNoLogin:
+	case deprovisionDrop:
+		if dc.GracePeriod <= 0 {
+			return fmt.Errorf("ldap_deprovisioning grace_period should be set for action drop")
+		}
+	default:
+		return fmt.Errorf("invalid ldap_deprovisioning action %s (should be revoke, nologin or drop)", dc.Action)
+	}
+	return nil
 }
 
 type FgaRoleConfig struct {
@@ -49,18 +92,27 @@ type FgaConfig struct {
 	GeneralConfig FgaGeneralConfig         `yaml:"general"`
 	StrictConfig  pg.StrictOptions         `yaml:"strict"`
 	LdapConfig    ldap.Config              `yaml:"ldap"`
+	Directories   map[string]ldap.Config   `yaml:"ldap_directories"`
 	PgDsn         pg.Dsn                   `yaml:"postgresql_dsn"`
+	Tablespaces   pg.Tablespaces           `yaml:"tablespaces"`
 	DbsConfig     pg.Databases             `yaml:"databases"`
 	UserConfig    map[string]FgaUserConfig `yaml:"users"`
 	Roles         map[string]FgaRoleConfig `yaml:"roles"`
-	Slots         []string                 `yaml:"replication_slots"`
+	Slots         pg.ReplicationSlots      `yaml:"replication_slots"`
+	SlotHealth    pg.SlotHealthOptions     `yaml:"replication_slot_health"`
+	HbaConfig     pg.HbaConfig             `yaml:"hba"`
+	Deprovision   FgaDeprovisionConfig     `yaml:"ldap_deprovisioning"`
+	Guard         pg.MassDeletionGuard     `yaml:"mass_deletion_guard"`
 }
 
 func NewConfig() (config FgaConfig, err error) {
 	var configFile string
 	var debug bool
 	var version bool
+	var allowMassDeletion bool
 	flag.BoolVar(&debug, "d", false, "Add debugging output")
+	flag.BoolVar(&allowMassDeletion, "allow-mass-deletion", false,
+		"Remove objects, also when that exceeds the thresholds of mass_deletion_guard")
 	flag.BoolVar(&version, "v", false, "Show version information")
 	flag.StringVar(&configFile, "c", os.Getenv(envConfName), "Path to configfile")
 
@@ -84,6 +136,26 @@ func NewConfig() (config FgaConfig, err error) {
 		return config, err
 	}
 	err = yaml.Unmarshal(yamlConfig, &config)
+	if err != nil {
+		return config, err
+	}
+	config.HbaConfig.SetDefaults()
 	config.GeneralConfig.Debug = config.GeneralConfig.Debug || debug
-	return config, err
+	config.GeneralConfig.AllowMassDeletion = allowMassDeletion
+	return config, config.Deprovision.validate()
+}
+
+// LdapDirectories returns all configured ldap directories by name. The ldap chapter is the directory named default.
+func (fc FgaConfig) LdapDirectories() (directories map[string]ldap.Config, err error) {
+	directories = make(map[string]ldap.Config)
+	for name, directory := range fc.Directories {
+		directories[name] = directory
+	}
+	if len(fc.LdapConfig.Servers) > 0 || len(directories) == 0 {
+		if _, exists := directories[defaultDirectory]; exists {
+			return nil, fmt.Errorf("ldap and ldap_directories.%s cannot both be configured", defaultDirectory)
+		}
+		directories[defaultDirectory] = fc.LdapConfig
+	}
+	return directories, nil
 }
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pgvillage-tools/pgfga/pkg/pg"
+)
+
+const (
+	deprovisionRevoke  = "revoke"
+	deprovisionNoLogin = "nologin"
+	deprovisionDrop    = "drop"
+)
+
+// managedByConfig returns true if the role is configured as a user or role, which means that its memberships are
+// not managed from ldap
+func (pfh PgFgaHandler) managedByConfig(roleName string) bool {
+	if _, exists := pfh.config.UserConfig[roleName]; exists {
+		return true
+	}
+	_, exists := pfh.config.Roles[roleName]
+	return exists
+}
+
+// keepReason returns why a role that is not an expected member of an ldap group role (anymore) is not revoked from it
+// (and not deprovisioned), or an empty string when it should be. Only roles that pgfga created from ldap (fromLdap)
+// are revoked, since other members are granted by other means.
+func (pfh PgFgaHandler) keepReason(roleName string, fromLdap map[string]bool) (reason string) {
+	switch {
+	case pg.IsProtectedRole(roleName):
+		return "it is a protected role"
+	case pfh.managedByConfig(roleName):
+		return fmt.Sprintf("%s is configured in the config file", roleName)
+	case !fromLdap[roleName]:
+		return fmt.Sprintf("%s was not created from ldap", roleName)
+	}
+	return ""
+}
+
+// revokeDeparted revokes the group role from all roles that were created from ldap, but are not an expected member
+// of the group (anymore), and returns the roles it was revoked from. With the mass deletion guard enabled, the
+// revokes (and the deprovisioning of the returned roles) are postponed until HandleDeletions has checked the
+// thresholds.
+func (pfh PgFgaHandler) revokeDeparted(groupName string, expected map[string]bool, fromLdap map[string]bool) (
+	revoked []string, err error) {
+	current, err := pfh.pg.RoleMembers(groupName)
+	if err != nil {
+		return nil, err
+	}
+	for _, memberName := range current {
+		if expected[memberName] {
+			continue
+		}
+		if reason := pfh.keepReason(memberName, fromLdap); reason != "" {
+			log.Debugf("not revoking %s from %s (%s)", groupName, memberName, reason)
+			continue
+		}
+		member, err := pfh.pg.GetRole(memberName)
+		if err != nil {
+			return nil, err
+		}
+		err = member.RevokeRole(groupName)
+		if err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, memberName)
+	}
+	return revoked, nil
+}
+
+// deprovision applies the configured action to users that departed from all ldap groups, and to users that departed
+// in an earlier run and are still within the grace period. Users that are a member of an ldap group (again) are no
+// longer marked as orphaned. Deprovisioning only runs with strict.users.
+func (pfh PgFgaHandler) deprovision(departed map[string]bool) (err error) {
+	config := pfh.config.Deprovision
+	err = config.validate()
+	if err != nil {
+		return err
+	}
+	if config.Action == "" || config.Action == deprovisionRevoke {
+		return nil
+	}
+	if !pfh.config.StrictConfig.Users {
+		log.Infof("not deprovisioning departed users with action %s (config.strict.users is not True)",
+			config.Action)
+		return nil
+	}
+	orphaned, err := pfh.pg.OrphanedRoles()
+	if err != nil {
+		return err
+	}
+	isOrphaned := make(map[string]bool)
+	for _, roleName := range orphaned {
+		isOrphaned[roleName] = true
+		if _, exists := departed[roleName]; !exists {
+			departed[roleName] = true
+		}
+	}
+	for roleName, isDeparted := range departed {
+		if !isDeparted && !isOrphaned[roleName] {
+			continue
+		}
+		role, err := pfh.pg.GetRole(roleName)
+		if err != nil {
+			return err
+		}
+		if !isDeparted {
+			err = role.ResetOrphaned()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if pg.IsProtectedRole(roleName) || pfh.managedByConfig(roleName) {
+			continue
+		}
+		if !isOrphaned[roleName] {
+			// The user departed in this run, and is locked out (which the mass deletion guard can postpone)
+			err = role.Deprovision()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = role.SetNoLogin()
+		if err != nil {
+			return err
+		}
+		since, err := role.OrphanedSince()
+		if err != nil {
+			return err
+		}
+		if config.Action != deprovisionDrop {
+			continue
+		}
+		if time.Since(since) < config.GracePeriod {
+			log.Debugf("not dropping orphaned user %s before %s", roleName,
+				since.Add(config.GracePeriod).Format(time.RFC3339))
+			continue
+		}
+		err = role.Drop()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package internal
+
+import "testing"
+
+func TestKeepReason(t *testing.T) {
+	pfh := PgFgaHandler{config: FgaConfig{
+		UserConfig: map[string]FgaUserConfig{"app": {Auth: "password"}},
+		Roles:      map[string]FgaRoleConfig{"dba": {}},
+	}}
+	fromLdap := map[string]bool{"jdoe": true, "app": true, "dba": true, "postgres": true, "pg_read_all_data": true}
+	for _, test := range []struct {
+		roleName string
+		revoke   bool
+	}{
+		{roleName: "jdoe", revoke: true},
+		// Granted manually, or by another tool
+		{roleName: "admin"},
+		{roleName: "app"},
+		{roleName: "dba"},
+		{roleName: "postgres"},
+		{roleName: "pg_read_all_data"},
+		{roleName: "pg_checkpoint"},
+	} {
+		t.Run(test.roleName, func(t *testing.T) {
+			reason := pfh.keepReason(test.roleName, fromLdap)
+			if revoke := reason == ""; revoke != test.revoke {
+				t.Errorf("expected revoke to be %t, got %t (%s)", test.revoke, revoke, reason)
+			}
+		})
+	}
+}
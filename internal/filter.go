@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pgvillage-tools/pgfga/pkg/ldap"
+	"github.com/pgvillage-tools/pgfga/pkg/pg"
+)
+
+// FgaMemberFilter selects users from an ldap group by name, by regular expression, or by ldap filter
+type FgaMemberFilter struct {
+	Names       []string `yaml:"names"`
+	Regexes     []string `yaml:"regexes"`
+	LdapFilters []string `yaml:"ldapfilters"`
+}
+
+func (mf FgaMemberFilter) isEmpty() bool {
+	return len(mf.Names) == 0 && len(mf.Regexes) == 0 && len(mf.LdapFilters) == 0
+}
+
+// matches returns true if the member matches any of the names, regular expressions or ldap filters
+func (mf FgaMemberFilter) matches(lh *ldap.Handler, member *ldap.Member) (matches bool, err error) {
+	name := member.Name()
+	for _, filterName := range mf.Names {
+		if filterName == name {
+			return true, nil
+		}
+	}
+	for _, filterRegex := range mf.Regexes {
+		matches, err = regexp.MatchString(filterRegex, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid regex %s: %v", filterRegex, err)
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	for _, filter := range mf.LdapFilters {
+		matches, err = lh.MatchesFilter(member, filter)
+		if err != nil {
+			return false, err
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// includeMember returns true if a role should be created for a member of an ldap group. Protected and predefined
+// roles, and the role pgfga is connected as (currentUser) are never created from ldap. Nested groups only get a role
+// with ldapgrouphierarchy, and are skipped (with all of their members) when they match the exclude filter. Users
+// should match the include filter (when set) and should not match the exclude filter.
+func includeMember(lh *ldap.Handler, userName string, userConfig FgaUserConfig, member *ldap.Member,
+	currentUser string) (include bool, err error) {
+	if pg.IsProtectedRole(member.Name()) || member.Name() == currentUser {
+		log.Warnf("skipping member %s of %s (it is a protected role)", member.Name(), userName)
+		return false, nil
+	}
+	isGroup := member.GetMType() == ldap.GroupMType
+	if isGroup && !userConfig.GroupHierarchy {
+		// The users of nested groups are members of the base group
+		return false, nil
+	}
+	if !isGroup && !userConfig.Include.isEmpty() {
+		include, err = userConfig.Include.matches(lh, member)
+		if err != nil {
+			return false, err
+		}
+		if !include {
+			log.Debugf("skipping member %s of %s (it does not match ldapinclude)", member.Name(), userName)
+			return false, nil
+		}
+	}
+	exclude, err := userConfig.Exclude.matches(lh, member)
+	if err != nil {
+		return false, err
+	}
+	if exclude {
+		log.Debugf("skipping member %s of %s (it matches ldapexclude)", member.Name(), userName)
+		return false, nil
+	}
+	return true, nil
+}
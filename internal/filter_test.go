@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/pgvillage-tools/pgfga/pkg/ldap"
+	"github.com/pgvillage-tools/pgfga/pkg/pg"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log = zap.NewNop().Sugar()
+	pg.Initialize(log)
+	ldap.Initialize(log)
+}
+
+func TestIncludeMember(t *testing.T) {
+	include := FgaMemberFilter{Names: []string{"jdoe", "pg_read_all_data", "pgfga"}}
+	exclude := FgaMemberFilter{Regexes: []string{"^svc_", "^contractors$"}}
+	for _, test := range []struct {
+		name       string
+		dn         string
+		hierarchy  bool
+		include    FgaMemberFilter
+		exclude    FgaMemberFilter
+		isIncluded bool
+	}{
+		{name: "user", dn: "uid=jdoe,ou=users,dc=pgfga,dc=org", isIncluded: true},
+		{name: "protected role", dn: "uid=postgres,ou=users,dc=pgfga,dc=org"},
+		{name: "predefined role", dn: "uid=pg_read_all_data,ou=users,dc=pgfga,dc=org", include: include},
+		{name: "current user", dn: "uid=pgfga,ou=users,dc=pgfga,dc=org", include: include},
+		{name: "included user", dn: "uid=jdoe,ou=users,dc=pgfga,dc=org", include: include, isIncluded: true},
+		{name: "not included user", dn: "uid=jane,ou=users,dc=pgfga,dc=org", include: include},
+		{name: "excluded user", dn: "uid=svc_backup,ou=users,dc=pgfga,dc=org", exclude: exclude},
+		{name: "group without hierarchy", dn: "cn=dba,ou=groups,dc=pgfga,dc=org"},
+		{
+			name:       "group with hierarchy is not filtered by include",
+			dn:         "cn=dba,ou=groups,dc=pgfga,dc=org",
+			hierarchy:  true,
+			include:    include,
+			isIncluded: true,
+		},
+		{
+			name:      "excluded group with hierarchy",
+			dn:        "cn=contractors,ou=groups,dc=pgfga,dc=org",
+			hierarchy: true,
+			exclude:   exclude,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			member, err := ldap.NewMember(test.dn)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			userConfig := FgaUserConfig{GroupHierarchy: test.hierarchy, Include: test.include, Exclude: test.exclude}
+			isIncluded, err := includeMember(nil, "ldapgroup", userConfig, member, "pgfga")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if isIncluded != test.isIncluded {
+				t.Errorf("expected include to be %t", test.isIncluded)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// interactive returns true if pgfga runs in a terminal, so that the user can confirm
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmMassDeletion asks the user to confirm the removals
+func confirmMassDeletion(deletions []string) (confirmed bool, err error) {
+	fmt.Println("pgfga is about to remove:")
+	for _, d := range deletions {
+		fmt.Printf("- %s\n", d)
+	}
+	fmt.Print("Type 'yes' to proceed: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(answer) == "yes", nil
+}
+
+// HandleDeletions applies all removals that are postponed by the mass deletion guard, unless they exceed its
+// thresholds. Removals that exceed the thresholds are only applied with --allow-mass-deletion, or after interactive
+// confirmation.
+func (pfh PgFgaHandler) HandleDeletions() (err error) {
+	exceeded, err := pfh.pg.CheckDeletions()
+	if err != nil {
+		return err
+	}
+	if len(exceeded) == 0 {
+		return pfh.pg.ApplyDeletions()
+	}
+	summary := strings.Join(exceeded, ", ")
+	log.Warnf("this run would remove %s, which exceeds the thresholds of mass_deletion_guard", summary)
+	switch {
+	case pfh.config.GeneralConfig.AllowMassDeletion:
+		log.Warnf("removing anyway (running with --allow-mass-deletion)")
+	case interactive():
+		confirmed, err := confirmMassDeletion(pfh.pg.PlannedDeletions())
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return fmt.Errorf("aborted by user, nothing is removed")
+		}
+	default:
+		return fmt.Errorf("aborting before removing %s (exceeds mass_deletion_guard, rerun with "+
+			"--allow-mass-deletion to proceed)", summary)
+	}
+	return pfh.pg.ApplyDeletions()
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pgvillage-tools/pgfga/pkg/pg"
+)
+
+const (
+	// defaultCertMap is the pg_ident.conf map used for clientcert users with a cert_cn but without a map
+	defaultCertMap = "pgfga_cert"
+	// defaultGssMap is the pg_ident.conf map used for gss users with a principal but without a map
+	defaultGssMap = "pgfga_gss"
+)
+
+// identMapping returns the pg_ident.conf mapping for a user, and sets the rule to reference it
+func identMapping(rule *pg.HbaRule, userName string, userConfig FgaUserConfig, systemUser string,
+	defaultMap string) (mapping pg.IdentMapping) {
+	mapping = pg.IdentMapping{
+		Map:        userConfig.IdentMap,
+		SystemUser: systemUser,
+		PgUser:     userName,
+	}
+	if mapping.Map == "" {
+		mapping.Map = defaultMap
+	}
+	if mapping.SystemUser == "" {
+		mapping.SystemUser = userName
+	}
+	options := map[string]string{"map": mapping.Map}
+	for name, value := range rule.Options {
+		options[name] = value
+	}
+	rule.Options = options
+	return mapping
+}
+
+// ldapHbaOptions returns the options for the pg_hba.conf ldap line of a user, which are the hba_options of its
+// directory. The ldap_options of the hba chapter apply to the default directory, or when only one directory is
+// configured.
+func (pfh PgFgaHandler) ldapHbaOptions(userName string, userConfig FgaUserConfig) (options map[string]string,
+	err error) {
+	name, err := pfh.directoryName(userName, userConfig)
+	if err != nil {
+		return nil, err
+	}
+	directories, err := pfh.config.LdapDirectories()
+	if err != nil {
+		return nil, err
+	}
+	if options = directories[name].HbaOptions; len(options) > 0 {
+		return options, nil
+	}
+	if name != defaultDirectory && len(directories) > 1 {
+		return nil, fmt.Errorf("hba_options should be set for ldap directory %s of %s (the ldap_options of the hba "+
+			"chapter only apply to the default directory)", name, userName)
+	}
+	return pfh.config.HbaConfig.LdapOptions, nil
+}
+
+// hbaRule returns the pg_hba.conf rule for a user, derived from its auth type, and the pg_ident.conf mappings it
+// references (if any)
+func (pfh PgFgaHandler) hbaRule(userName string, userConfig FgaUserConfig) (rule pg.HbaRule,
+	mappings []pg.IdentMapping, err error) {
+	hbaConfig := pfh.config.HbaConfig
+	rule = pg.HbaRule{
+		ConnectionType: hbaConfig.ConnectionType,
+		Databases:      hbaConfig.Databases,
+		User:           pg.HbaUser(userName),
+		Addresses:      hbaConfig.Addresses,
+	}
+	if len(userConfig.HbaDatabases) > 0 {
+		rule.Databases = userConfig.HbaDatabases
+	}
+	if len(userConfig.HbaAddresses) > 0 {
+		rule.Addresses = userConfig.HbaAddresses
+	}
+	if userConfig.CertCN != "" && userConfig.Auth != "clientcert" {
+		return rule, nil, fmt.Errorf("cert_cn can only be set for users with auth: clientcert (user %s)", userName)
+	}
+	if userConfig.Principal != "" && userConfig.Auth != "gss" {
+		return rule, nil, fmt.Errorf("principal can only be set for users with auth: gss (user %s)", userName)
+	}
+	if userConfig.IdentMap != "" && userConfig.Auth != "clientcert" && userConfig.Auth != "gss" {
+		return rule, nil, fmt.Errorf("map can only be set for users with auth: clientcert or gss (user %s)", userName)
+	}
+	switch userConfig.Auth {
+	case "ldap-group":
+		// Members of the group role are all users synced from ldap
+		lh, err := pfh.ldapHandler(userName, userConfig)
+		if err != nil {
+			return rule, nil, err
+		}
+		groupName, err := lh.GroupName(userConfig.BaseDN)
+		if err != nil {
+			return rule, nil, err
+		}
+		rule.User = pg.HbaGroup(groupName)
+		rule.Method = "ldap"
+		rule.Options, err = pfh.ldapHbaOptions(userName, userConfig)
+		if err != nil {
+			return rule, nil, err
+		}
+	case "ldap-user":
+		rule.Method = "ldap"
+		rule.Options = hbaConfig.LdapOptions
+		if userConfig.Directory != "" {
+			rule.Options, err = pfh.ldapHbaOptions(userName, userConfig)
+			if err != nil {
+				return rule, nil, err
+			}
+		}
+	case "clientcert":
+		rule.ConnectionType = "hostssl"
+		rule.Method = "cert"
+		if userConfig.CertCN != "" || userConfig.IdentMap != "" {
+			mappings = append(mappings, identMapping(&rule, userName, userConfig, userConfig.CertCN, defaultCertMap))
+		}
+	case "gss":
+		rule.Method = "gss"
+		rule.Options = hbaConfig.GssOptions
+		if userConfig.Principal != "" || userConfig.IdentMap != "" {
+			mappings = append(mappings, identMapping(&rule, userName, userConfig, userConfig.Principal,
+				defaultGssMap))
+		}
+	case "password", "md5":
+		rule.Method = hbaConfig.PasswordMethod
+	default:
+		return rule, nil, fmt.Errorf("invalid auth %s for user %s", userConfig.Auth, userName)
+	}
+	return rule, mappings, nil
+}
+
+func (pfh PgFgaHandler) HandleHba() (err error) {
+	if !pfh.config.HbaConfig.Enabled {
+		return nil
+	}
+	var userNames []string
+	for userName := range pfh.config.UserConfig {
+		userNames = append(userNames, userName)
+	}
+	sort.Strings(userNames)
+	var rules []pg.HbaRule
+	var mappings []pg.IdentMapping
+	for _, userName := range userNames {
+		userConfig := pfh.config.UserConfig[userName]
+		if !userConfig.State.Bool() {
+			continue
+		}
+		rule, userMappings, err := pfh.hbaRule(userName, userConfig)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+		mappings = append(mappings, userMappings...)
+	}
+	return pfh.pg.ApplyHba(pfh.config.HbaConfig, rules, mappings)
+}
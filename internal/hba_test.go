@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pgvillage-tools/pgfga/pkg/ldap"
+	"github.com/pgvillage-tools/pgfga/pkg/pg"
+)
+
+func TestLdapHbaOptions(t *testing.T) {
+	corporate := map[string]string{"ldapserver": "ad.example.com"}
+	contractors := map[string]string{"ldapserver": "ldap.contractors.example.com"}
+	global := map[string]string{"ldapserver": "ldap.example.com"}
+	for _, test := range []struct {
+		name        string
+		directories map[string]ldap.Config
+		ldapConfig  ldap.Config
+		userConfig  FgaUserConfig
+		expected    map[string]string
+		fails       bool
+	}{
+		{
+			name: "options of the directory of the group",
+			directories: map[string]ldap.Config{
+				"corporate":   {HbaOptions: corporate},
+				"contractors": {HbaOptions: contractors},
+			},
+			userConfig: FgaUserConfig{Auth: "ldap-group", Directory: "contractors"},
+			expected:   contractors,
+		},
+		{
+			name: "hba chapter options for the default directory",
+			directories: map[string]ldap.Config{
+				"contractors": {HbaOptions: contractors},
+			},
+			ldapConfig: ldap.Config{Servers: []string{"ldap://ldap.example.com"}},
+			userConfig: FgaUserConfig{Auth: "ldap-group"},
+			expected:   global,
+		},
+		{
+			name:        "hba chapter options for the only directory",
+			directories: map[string]ldap.Config{"contractors": {}},
+			userConfig:  FgaUserConfig{Auth: "ldap-group"},
+			expected:    global,
+		},
+		{
+			name: "other directory without options",
+			directories: map[string]ldap.Config{
+				"corporate":   {HbaOptions: corporate},
+				"contractors": {},
+			},
+			userConfig: FgaUserConfig{Auth: "ldap-group", Directory: "contractors"},
+			fails:      true,
+		},
+		{
+			name: "ldap user of a directory",
+			directories: map[string]ldap.Config{
+				"corporate":   {HbaOptions: corporate},
+				"contractors": {HbaOptions: contractors},
+			},
+			userConfig: FgaUserConfig{Auth: "ldap-user", Directory: "corporate"},
+			expected:   corporate,
+		},
+		{
+			name: "ldap user without a directory",
+			directories: map[string]ldap.Config{
+				"corporate":   {HbaOptions: corporate},
+				"contractors": {HbaOptions: contractors},
+			},
+			userConfig: FgaUserConfig{Auth: "ldap-user"},
+			expected:   global,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pfh := PgFgaHandler{config: FgaConfig{
+				LdapConfig:  test.ldapConfig,
+				Directories: test.directories,
+				HbaConfig:   pg.HbaConfig{LdapOptions: global},
+			}}
+			directories, err := pfh.config.LdapDirectories()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			pfh.ldap = make(map[string]*ldap.Handler)
+			for name, directory := range directories {
+				pfh.ldap[name] = ldap.NewLdapHandler(directory)
+			}
+			test.userConfig.BaseDN = "cn=dba,ou=groups,dc=example,dc=com"
+			rule, _, err := pfh.hbaRule("dba", test.userConfig)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(rule.Options, test.expected) {
+				t.Errorf("expected options %v, got %v", test.expected, rule.Options)
+			}
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -32,7 +34,7 @@ func Initialize() {
 type PgFgaHandler struct {
 	config FgaConfig
 	pg     *pg.Handler
-	ldap   *ldap.Handler
+	ldap   map[string]*ldap.Handler
 }
 
 func NewPgFgaHandler() (pfh *PgFgaHandler, err error) {
@@ -47,9 +49,18 @@ func NewPgFgaHandler() (pfh *PgFgaHandler, err error) {
 		config: config,
 	}
 
-	pfh.ldap = ldap.NewLdapHandler(config.LdapConfig)
+	directories, err := config.LdapDirectories()
+	if err != nil {
+		return nil, err
+	}
+	pfh.ldap = make(map[string]*ldap.Handler)
+	for name, directory := range directories {
+		directory.Name = name
+		pfh.ldap[name] = ldap.NewLdapHandler(directory)
+	}
 
-	pfh.pg = pg.NewPgHandler(config.PgDsn, config.StrictConfig, config.DbsConfig, config.Slots)
+	pfh.pg = pg.NewPgHandler(config.PgDsn, config.StrictConfig, config.DbsConfig, config.Slots,
+		config.SlotHealth, config.Tablespaces, config.Guard)
 
 	return pfh, nil
 }
@@ -65,6 +76,14 @@ func (pfh PgFgaHandler) Handle() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = pfh.HandleHba()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = pfh.HandleTablespaces()
+	if err != nil {
+		log.Fatal(err)
+	}
 	err = pfh.HandleDatabases()
 	if err != nil {
 		log.Fatal(err)
@@ -73,10 +92,31 @@ func (pfh PgFgaHandler) Handle() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Tablespaces are dropped after the databases (the mass deletion guard applies postponed removals in order)
+	err = pfh.HandleAbsentTablespaces()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = pfh.HandleDeletions()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func (pfh PgFgaHandler) HandleUsers() (err error) {
-	for userName, userConfig := range pfh.config.UserConfig {
+	// departed holds all users that are or were a member of an ldap group, and if they departed from all ldap groups
+	departed := make(map[string]bool)
+	// ldapMembers holds the configuration of all users of ldap groups, to detect conflicts between groups
+	ldapMembers := make(map[string]ldapMemberConfig)
+	var cached bool
+	// Users are handled in a fixed order, so that every run has the same result
+	var userNames []string
+	for userName := range pfh.config.UserConfig {
+		userNames = append(userNames, userName)
+	}
+	sort.Strings(userNames)
+	for _, userName := range userNames {
+		userConfig := pfh.config.UserConfig[userName]
 		options := make(pg.RoleOptions)
 		for _, optionName := range userConfig.Options {
 			option, err := pg.NewRoleOption(optionName)
@@ -87,33 +127,23 @@ func (pfh PgFgaHandler) HandleUsers() (err error) {
 		}
 		switch userConfig.Auth {
 		case "ldap-group":
-			log.Debugf("Configuring role from ldap for %s", userName)
-			if userConfig.BaseDN == "" || userConfig.Filter == "" {
-				return fmt.Errorf("ldapbasedn and ldapfilter must be set for %s (auth: 'ldap-group')", userName)
-			}
-			baseGroup, err := pfh.ldap.GetMembers(userConfig.BaseDN, userConfig.Filter)
+			groupMembers, err := pfh.handleLdapGroup(userName, userConfig, options, ldapMembers)
 			if err != nil {
 				return err
 			}
-			baseRole, err := pg.NewRole(pfh.pg, baseGroup.Name(), options, userConfig.State)
-			if err != nil {
-				return err
-			}
-			err = baseRole.ResetPassword()
-			if err != nil {
-				return err
+			if groupMembers == nil {
+				// The members are read from the ldap snapshot, so departed users cannot be determined
+				cached = true
+				continue
 			}
-			for _, ms := range baseGroup.MembershipTree() {
-				_, err = pg.NewRole(pfh.pg, ms.Member.Name(), pg.LoginOptions, userConfig.State)
-				if err != nil {
-					return err
-				}
-				err = pfh.pg.GrantRole(ms.Member.Name(), baseGroup.Name())
-				if err != nil {
-					return err
+			for member, isDeparted := range groupMembers {
+				// A user that still is a member of any of the ldap groups has not departed
+				if previous, exists := departed[member]; exists {
+					isDeparted = isDeparted && previous
 				}
+				departed[member] = isDeparted
 			}
-		case "ldap-user", "clientcert":
+		case "ldap-user", "clientcert", "gss":
 			log.Debugf("Configuring user %s with %s", userName, userConfig.Auth)
 			options.AddOption(pg.LoginOption)
 			user, err := pg.NewRole(pfh.pg, userName, options, userConfig.State)
@@ -151,9 +181,329 @@ func (pfh PgFgaHandler) HandleUsers() (err error) {
 			log.Fatalf("Invalid auth %s for user %s", userConfig.Auth, userName)
 		}
 	}
+	if cached {
+		log.Warnf("not deprovisioning departed users (members of some ldap groups are read from the ldap snapshot)")
+		return nil
+	}
+	return pfh.deprovision(departed)
+}
+
+// ldapUserOptions returns the role options for a user of an ldap group, which are LOGIN and the member_options, or
+// NOLOGIN when the account is disabled in ldap
+func ldapUserOptions(userConfig FgaUserConfig, member *ldap.Member) (options pg.RoleOptions, err error) {
+	options = make(pg.RoleOptions)
+	options.AddOption(pg.LoginOption)
+	for _, optionName := range userConfig.MemberOptions {
+		option, err := pg.NewRoleOption(optionName)
+		if err != nil {
+			return nil, err
+		}
+		options.AddOption(option)
+	}
+	if member.Disabled() {
+		log.Debugf("account of %s is disabled in ldap", member.Name())
+		options.AddOption(pg.LoginOption.Inverse())
+	}
+	return options, nil
+}
+
+// ldapMemberConfig is the configuration a user gets from an ldap group. A user can be a member of multiple ldap
+// groups, which should not configure it differently.
+type ldapMemberConfig struct {
+	group           string
+	options         pg.RoleOptions
+	settings        map[string]string
+	connectionLimit *int
+	manageExpiry    bool
+	expiry          time.Time
+}
+
+// newLdapMemberConfig returns the configuration for a user of an ldap group, where the expiry is the earliest of
+// member_expiry and the expiry in ldap
+func newLdapMemberConfig(lh *ldap.Handler, groupName string, userConfig FgaUserConfig, member *ldap.Member) (
+	mc ldapMemberConfig, err error) {
+	mc = ldapMemberConfig{
+		group:           groupName,
+		settings:        userConfig.MemberSettings,
+		connectionLimit: userConfig.MemberConnectionLimit,
+		manageExpiry:    !userConfig.MemberExpiry.IsZero(),
+		expiry:          userConfig.MemberExpiry,
+	}
+	mc.options, err = ldapUserOptions(userConfig, member)
+	if err != nil {
+		return mc, err
+	}
+	if lh.ManagesExpiry() && member.Dn() != "" {
+		mc.manageExpiry = true
+		ldapExpiry := member.Expiry()
+		if !ldapExpiry.IsZero() && (mc.expiry.IsZero() || ldapExpiry.Before(mc.expiry)) {
+			mc.expiry = ldapExpiry
+		}
+	}
+	return mc, nil
+}
+
+// conflicts returns an error when the configuration of a user from another ldap group differs. Options and settings
+// that are only set by one of the groups are combined.
+func (mc ldapMemberConfig) conflicts(userName string, other ldapMemberConfig) (err error) {
+	var conflicts []string
+	for name, option := range mc.options {
+		if otherOption, exists := other.options[name]; exists && otherOption != option {
+			conflicts = append(conflicts, fmt.Sprintf("%s / %s", option, otherOption))
+		}
+	}
+	for name, value := range mc.settings {
+		if otherValue, exists := other.settings[name]; exists && otherValue != value {
+			conflicts = append(conflicts, fmt.Sprintf("%s = %s / %s", name, value, otherValue))
+		}
+	}
+	if mc.connectionLimit != nil && other.connectionLimit != nil && *mc.connectionLimit != *other.connectionLimit {
+		conflicts = append(conflicts, fmt.Sprintf("connection limit %d / %d", *mc.connectionLimit,
+			*other.connectionLimit))
+	}
+	if mc.manageExpiry && other.manageExpiry && !mc.expiry.Equal(other.expiry) {
+		conflicts = append(conflicts, fmt.Sprintf("expiry %s / %s", mc.expiry.Format(time.RFC3339),
+			other.expiry.Format(time.RFC3339)))
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Strings(conflicts)
+	return fmt.Errorf("user %s is configured differently by ldap groups %s and %s (%s)", userName, mc.group,
+		other.group, strings.Join(conflicts, ", "))
+}
+
+// configureLdapUser sets the expiry, the member_settings and the member_connection_limit for a user of an ldap group
+func configureLdapUser(role *pg.Role, mc ldapMemberConfig) (err error) {
+	if mc.manageExpiry {
+		err = role.SetExpiry(mc.expiry)
+		if err != nil {
+			return err
+		}
+	}
+	var settingNames []string
+	for name := range mc.settings {
+		settingNames = append(settingNames, name)
+	}
+	sort.Strings(settingNames)
+	for _, name := range settingNames {
+		err = role.SetSetting(name, mc.settings[name])
+		if err != nil {
+			return err
+		}
+	}
+	if mc.connectionLimit != nil {
+		return role.SetConnectionLimit(*mc.connectionLimit)
+	}
 	return nil
 }
 
+// configureLdapMember creates the role for a member of an ldap group, and (for users) sets the configuration from the
+// ldap group
+func (pfh PgFgaHandler) configureLdapMember(memberName string, options pg.RoleOptions, state pg.State,
+	member *ldap.Member, mc *ldapMemberConfig) (err error) {
+	role, err := pg.NewRole(pfh.pg, memberName, options, state)
+	if err != nil {
+		return err
+	}
+	err = setLdapComment(role, member, state)
+	if err != nil || !state.Bool() {
+		return err
+	}
+	err = role.SetFromLdap()
+	if err != nil || mc == nil {
+		return err
+	}
+	return configureLdapUser(role, *mc)
+}
+
+// setLdapComment sets the comment of a role from the comment attributes of its ldap entry (if read)
+func setLdapComment(role *pg.Role, member *ldap.Member, state pg.State) (err error) {
+	comment, commented := member.Comment()
+	if !commented || !state.Bool() {
+		return nil
+	}
+	return role.SetComment(comment)
+}
+
+// handleLdapGroup creates the group role and a user for all members of the ldap group, and (with strict.users) revokes
+// the group role from users that were created from ldap, but are no longer a member. It returns all current and
+// departed members, where departed members are true, or nil when the members are read from the ldap snapshot (and
+// departed members cannot be determined).
+func (pfh PgFgaHandler) handleLdapGroup(userName string, userConfig FgaUserConfig, options pg.RoleOptions,
+	ldapMembers map[string]ldapMemberConfig) (members map[string]bool, err error) {
+	log.Debugf("Configuring role from ldap for %s", userName)
+	if userConfig.BaseDN == "" || userConfig.Filter == "" {
+		return nil, fmt.Errorf("ldapbasedn and ldapfilter must be set for %s (auth: 'ldap-group')", userName)
+	}
+	lh, err := pfh.ldapHandler(userName, userConfig)
+	if err != nil {
+		return nil, err
+	}
+	baseGroup, err := lh.GetMembers(ldap.GroupSearch{
+		BaseDN:           userConfig.BaseDN,
+		Filter:           userConfig.Filter,
+		UserFilter:       userConfig.UserFilter,
+		MemberAttributes: userConfig.MemberAttrs,
+		MemberFilters: append(append([]string{}, userConfig.Include.LdapFilters...),
+			userConfig.Exclude.LdapFilters...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	baseRole, err := pg.NewRole(pfh.pg, baseGroup.Name(), options, userConfig.State)
+	if err != nil {
+		return nil, err
+	}
+	err = baseRole.ResetPassword()
+	if err != nil {
+		return nil, err
+	}
+	err = setLdapComment(baseRole, baseGroup, userConfig.State)
+	if err != nil {
+		return nil, err
+	}
+	currentUser, err := pfh.pg.CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	members = make(map[string]bool)
+	// groupMembers holds the expected members of all group roles
+	groupMembers := map[string]map[string]bool{baseGroup.Name(): {}}
+	for _, ms := range lh.MembershipTree(baseGroup) {
+		memberName := ms.Member.Name()
+		if _, exists := groupMembers[ms.MemberOf.Name()]; userConfig.GroupHierarchy && !exists {
+			// The parent group was skipped, and so are its members
+			continue
+		}
+		include, err := includeMember(lh, userName, userConfig, ms.Member, currentUser)
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+		members[memberName] = false
+		// With ldapgrouphierarchy, groups become NOLOGIN roles, and all other members are users
+		isGroupRole := userConfig.GroupHierarchy && ms.Member.GetMType() == ldap.GroupMType
+		groupName := baseGroup.Name()
+		memberOptions := pg.NoLoginOptions
+		if userConfig.GroupHierarchy {
+			groupName = ms.MemberOf.Name()
+		}
+		// mc is the configuration of a user (and nil for a group role)
+		var mc *ldapMemberConfig
+		if isGroupRole {
+			if _, exists := groupMembers[memberName]; !exists {
+				groupMembers[memberName] = make(map[string]bool)
+			}
+		} else {
+			memberConfig, err := newLdapMemberConfig(lh, userName, userConfig, ms.Member)
+			if err != nil {
+				return nil, err
+			}
+			mc = &memberConfig
+			memberOptions = mc.options
+			if other, exists := ldapMembers[memberName]; exists && userConfig.State.Bool() {
+				err = mc.conflicts(memberName, other)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if pfh.managedByConfig(memberName) {
+			// The config file wins: the role is only granted the group role
+			log.Debugf("not configuring %s from ldap group %s (%s is configured in the config file)", memberName,
+				userName, memberName)
+		} else {
+			err = pfh.configureLdapMember(memberName, memberOptions, userConfig.State, ms.Member, mc)
+			if err != nil {
+				return nil, err
+			}
+			if mc != nil && userConfig.State.Bool() {
+				ldapMembers[memberName] = *mc
+			}
+		}
+		err = pfh.pg.GrantRole(memberName, groupName)
+		if err != nil {
+			return nil, err
+		}
+		groupMembers[groupName][memberName] = true
+	}
+	if !userConfig.State.Bool() {
+		return members, nil
+	}
+	if cachedAt := baseGroup.CachedAt(); !cachedAt.IsZero() {
+		log.Warnf("not revoking %s from departed members (the members are read from the ldap snapshot from %s)",
+			baseGroup.Name(), cachedAt.Format(time.RFC3339))
+		return nil, nil
+	}
+	if !pfh.config.StrictConfig.Users {
+		log.Debugf("not revoking %s from departed members (config.strict.users is not True)", baseGroup.Name())
+		return members, nil
+	}
+	roleNames, err := pfh.pg.FromLdapRoles()
+	if err != nil {
+		return nil, err
+	}
+	fromLdap := make(map[string]bool)
+	for _, roleName := range roleNames {
+		fromLdap[roleName] = true
+	}
+	var departed []string
+	for groupName, expected := range groupMembers {
+		revoked, err := pfh.revokeDeparted(groupName, expected, fromLdap)
+		if err != nil {
+			return nil, err
+		}
+		departed = append(departed, revoked...)
+	}
+	for _, member := range departed {
+		if _, exists := members[member]; !exists {
+			members[member] = true
+		}
+	}
+	return members, nil
+}
+
+// ldapHandler returns the handler for the ldap directory of a user. The directory can be omitted when the ldap
+// chapter is configured, or when only one directory is configured.
+func (pfh PgFgaHandler) ldapHandler(userName string, userConfig FgaUserConfig) (lh *ldap.Handler, err error) {
+	name, err := pfh.directoryName(userName, userConfig)
+	if err != nil {
+		return nil, err
+	}
+	return pfh.ldap[name], nil
+}
+
+// directoryName returns the name of the ldap directory of a user (see ldapHandler)
+func (pfh PgFgaHandler) directoryName(userName string, userConfig FgaUserConfig) (name string, err error) {
+	name = userConfig.Directory
+	if name == "" {
+		if _, exists := pfh.ldap[defaultDirectory]; exists || len(pfh.ldap) != 1 {
+			name = defaultDirectory
+		} else {
+			for name = range pfh.ldap {
+			}
+		}
+	}
+	if _, exists := pfh.ldap[name]; !exists {
+		if userConfig.Directory == "" {
+			return "", fmt.Errorf("directory must be set for %s (multiple ldap directories are configured)", userName)
+		}
+		return "", fmt.Errorf("ldap directory %s of %s is not configured", name, userName)
+	}
+	return name, nil
+}
+
+func (pfh PgFgaHandler) HandleTablespaces() (err error) {
+	return pfh.pg.CreateTablespaces()
+}
+
+func (pfh PgFgaHandler) HandleAbsentTablespaces() (err error) {
+	return pfh.pg.DropTablespaces()
+}
+
 func (pfh PgFgaHandler) HandleDatabases() (err error) {
 	return pfh.pg.CreateOrDropDatabases()
 }
@@ -186,5 +536,9 @@ func (pfh PgFgaHandler) HandleRoles() (err error) {
 	return nil
 }
 func (pfh PgFgaHandler) HandleSlots() (err error) {
+	err = pfh.pg.CheckSlotHealth()
+	if err != nil {
+		return err
+	}
 	return pfh.pg.CreateOrDropSlots()
 }
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pgvillage-tools/pgfga/pkg/pg"
+)
+
+func TestLdapMemberConfigConflicts(t *testing.T) {
+	options := func(names ...string) pg.RoleOptions {
+		ro := make(pg.RoleOptions)
+		for _, name := range names {
+			option, err := pg.NewRoleOption(name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ro.AddOption(option)
+		}
+		return ro
+	}
+	limit := func(l int) *int { return &l }
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := ldapMemberConfig{
+		group:           "dba",
+		options:         options("LOGIN", "NOINHERIT"),
+		settings:        map[string]string{"statement_timeout": "30s"},
+		connectionLimit: limit(5),
+		manageExpiry:    true,
+		expiry:          expiry,
+	}
+	for _, test := range []struct {
+		name      string
+		other     ldapMemberConfig
+		conflicts bool
+	}{
+		{name: "same", other: base},
+		{
+			name: "other options and settings are combined",
+			other: ldapMemberConfig{
+				options:  options("LOGIN", "REPLICATION"),
+				settings: map[string]string{"work_mem": "64MB"},
+			},
+		},
+		{name: "conflicting option", other: ldapMemberConfig{options: options("INHERIT")}, conflicts: true},
+		{
+			name:      "conflicting setting",
+			other:     ldapMemberConfig{settings: map[string]string{"statement_timeout": "1min"}},
+			conflicts: true,
+		},
+		{name: "conflicting connection limit", other: ldapMemberConfig{connectionLimit: limit(10)}, conflicts: true},
+		{
+			name:      "conflicting expiry",
+			other:     ldapMemberConfig{manageExpiry: true, expiry: expiry.AddDate(1, 0, 0)},
+			conflicts: true,
+		},
+		{name: "unmanaged expiry", other: ldapMemberConfig{expiry: expiry.AddDate(1, 0, 0)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.other.group = "developers"
+			err := base.conflicts("jdoe", test.other)
+			if conflicts := err != nil; conflicts != test.conflicts {
+				t.Errorf("expected conflicts to be %t, got %v", test.conflicts, err)
+			}
+		})
+	}
+}
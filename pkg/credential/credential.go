@@ -1,12 +1,15 @@
-package ldap
+// Package credential holds the logic to retrieve secrets (like passwords) from config, files or executables
+package credential
 
 import (
 	"encoding/base64"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 )
 
+// Credential can be used to set a value directly, or read it from a file or the output of an executable
 type Credential struct {
 	Value  string `yaml:"value"`
 	File   string `yaml:"file"`
@@ -45,19 +48,15 @@ func fromFile(filename string) (value string, err error) {
 	// The intent is to give an option to retrieve a password from a file.
 	// As such opening a file which name is set by a variable is sort of the point.
 	// #nosec
-	file, err := os.Open(filename) // For read access.
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return "", err
 	}
-	data := make([]byte, 100)
-	count, err := file.Read(data)
-	if err != nil {
-		return "", err
-	}
-	if count == 0 {
+	value = strings.TrimSpace(string(data))
+	if value == "" {
 		return "", fmt.Errorf("file %s is empty", filename)
 	}
-	return string(data[:]), nil
+	return value, nil
 }
 
 func (c *Credential) GetCred() (value string, err error) {
@@ -71,7 +70,7 @@ func (c *Credential) GetCred() (value string, err error) {
 		return "", fmt.Errorf("either value or file must be set in a credential")
 	}
 	if c.Base64 {
-		data, err := base64.StdEncoding.DecodeString(value)
+		data, err := base64.StdEncoding.DecodeString(c.Value)
 		if err != nil {
 			return "", err
 		}
@@ -0,0 +1,59 @@
+package credential
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFromFile(t *testing.T) {
+	long := "host=primary.example.com port=5432 dbname=postgres user=replicator " +
+		"sslmode=verify-full sslrootcert=/etc/pgfga/root.crt application_name=pgfga"
+	for _, test := range []struct {
+		name     string
+		content  string
+		expected string
+		fails    bool
+	}{
+		{name: "plain", content: "secret", expected: "secret"},
+		{name: "trailing newline", content: "secret\n", expected: "secret"},
+		{name: "longer than 100 bytes", content: long + "\n", expected: long},
+		{name: "empty", content: "", fails: true},
+		{name: "only whitespace", content: " \n", fails: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "credential")
+			if err := os.WriteFile(filename, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			value, err := fromFile(filename)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error, got %q", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, value)
+			}
+			if strings.ContainsRune(value, 0) {
+				t.Errorf("value contains NUL bytes: %q", value)
+			}
+		})
+	}
+}
+
+func TestGetCredBase64(t *testing.T) {
+	c := Credential{Value: "c2VjcmV0", Base64: true}
+	value, err := c.GetCred()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "secret" {
+		t.Errorf("expected %q, got %q", "secret", value)
+	}
+}
@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// ActiveDirectory is the directory type for Microsoft Active Directory
+	ActiveDirectory = "ad"
+	// matchingRuleInChain makes AD resolve (transitive) membership of nested groups server side
+	matchingRuleInChain = "1.2.840.113556.1.4.1941"
+	rangeOption         = ";range="
+)
+
+// domainRoot returns the dn of the domain (all trailing dc components) of a dn
+func domainRoot(dn string) (root string, err error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+	var dcs []string
+	for i := len(parsed.RDNs) - 1; i >= 0; i-- {
+		rdn := parsed.RDNs[i]
+		if len(rdn.Attributes) != 1 || !strings.EqualFold(rdn.Attributes[0].Type, "dc") {
+			break
+		}
+		dcs = append([]string{"dc=" + rdn.Attributes[0].Value}, dcs...)
+	}
+	if len(dcs) == 0 {
+		return "", fmt.Errorf("cannot derive the domain from %s (set user_base_dn)", dn)
+	}
+	return strings.Join(dcs, ","), nil
+}
+
+// getInChainUsers adds all users that are (transitive) members of the base group as direct members of the base group.
+// Active Directory resolves nested groups server side with the LDAP_MATCHING_RULE_IN_CHAIN matching rule.
+func (lh *Handler) getInChainUsers(baseGroup *Member, search GroupSearch) (err error) {
+	baseDN := lh.config.UserBaseDN
+	if baseDN == "" {
+		baseDN, err = domainRoot(search.BaseDN)
+		if err != nil {
+			return err
+		}
+	}
+	// The filter of the group search applies to the group itself, and the user filter to its users
+	group, err := lh.getFilteredEntry(search.BaseDN, search.Filter, []string{"dn"})
+	if err != nil {
+		return err
+	}
+	if group == nil {
+		return fmt.Errorf("group %s does not exist, or does not match %s", search.BaseDN, search.Filter)
+	}
+	filter := fmt.Sprintf("(&(objectClass=user)(!(objectClass=computer))(memberOf:%s:=%s)%s)", matchingRuleInChain,
+		ldap.EscapeFilter(search.BaseDN), search.UserFilter)
+	searchRequest := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, 0, false,
+		filter, append([]string{"dn", "objectClass"}, lh.config.Mapping.userAttributes()...), nil)
+	sr, err := lh.search(searchRequest)
+	if err != nil {
+		return err
+	}
+	if len(sr.Entries) == 0 {
+		// Rather than revoking the group role from all of its users
+		return fmt.Errorf("in chain search for users of group %s in %s returned no users", search.BaseDN, baseDN)
+	}
+	for _, entry := range sr.Entries {
+		err = lh.addUserEntry(baseGroup, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attributeValues returns all values of an attribute. When the server returns the values in ranges
+// (e.a. member;range=0-1499, as Active Directory does for large groups), all remaining ranges are retrieved too.
+func (lh *Handler) attributeValues(entry *ldap.Entry, attribute string) (values []string, err error) {
+	values = entry.GetEqualFoldAttributeValues(attribute)
+	rangePrefix := strings.ToLower(attribute + rangeOption)
+	for _, attr := range entry.Attributes {
+		if !strings.HasPrefix(strings.ToLower(attr.Name), rangePrefix) {
+			continue
+		}
+		values = append(values, attr.Values...)
+		rangeSpec := attr.Name[len(rangePrefix):]
+		for !strings.HasSuffix(rangeSpec, "-*") {
+			bounds := strings.SplitN(rangeSpec, "-", 2)
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("invalid range %s for attribute %s of %s", rangeSpec, attribute, entry.DN)
+			}
+			end, err := strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %s for attribute %s of %s", rangeSpec, attribute, entry.DN)
+			}
+			next, err := lh.getEntry(entry.DN, []string{fmt.Sprintf("%s%s%d-*", attribute, rangeOption, end+1)})
+			if err != nil {
+				return nil, err
+			}
+			if next == nil {
+				return nil, fmt.Errorf("%s disappeared while retrieving values of %s", entry.DN, attribute)
+			}
+			rangeSpec = ""
+			for _, nextAttr := range next.Attributes {
+				if strings.HasPrefix(strings.ToLower(nextAttr.Name), rangePrefix) {
+					values = append(values, nextAttr.Values...)
+					rangeSpec = nextAttr.Name[len(rangePrefix):]
+				}
+			}
+			if rangeSpec == "" {
+				break
+			}
+		}
+	}
+	return values, nil
+}
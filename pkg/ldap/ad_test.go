@@ -0,0 +1,298 @@
+package ldap
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// newADStandIn returns a stand-in for an Active Directory with nested groups, a computer account and a group with
+// more members than fit in one range:
+//   - dba: alice, build01 (a computer) and oncall
+//   - oncall: bob and dba (a cycle)
+//   - big: user0000 up to user1199
+func newADStandIn() *standIn {
+	si := &standIn{rangeSize: 500}
+	user := func(name string, objectClasses ...string) {
+		si.add(adUserDn(name), map[string][]string{
+			"objectClass":    append([]string{"top", "person", "user"}, objectClasses...),
+			"sAMAccountName": {name},
+		})
+	}
+	user("alice")
+	user("bob")
+	user("build01$", "computer")
+	si.add("CN=dba,OU=Groups,DC=example,DC=com", map[string][]string{
+		"objectClass": {"top", "group"},
+		"member": {"CN=Alice,OU=Users,DC=example,DC=com", "CN=Build01$,OU=Users,DC=example,DC=com",
+			"CN=oncall,OU=Groups,DC=example,DC=com"},
+	})
+	si.add("CN=oncall,OU=Groups,DC=example,DC=com", map[string][]string{
+		"objectClass": {"top", "group"},
+		"member":      {"CN=Bob,OU=Users,DC=example,DC=com", "CN=dba,OU=Groups,DC=example,DC=com"},
+	})
+	var members []string
+	for i := 0; i < 1200; i++ {
+		name := fmt.Sprintf("user%04d", i)
+		user(name)
+		members = append(members, adUserDn(name))
+	}
+	si.add("CN=big,OU=Groups,DC=example,DC=com", map[string][]string{
+		"objectClass": {"top", "group"},
+		"member":      members,
+	})
+	return si
+}
+
+// adUserDn returns the dn of a user in the Active Directory stand-in, where the CN is capitalized
+func adUserDn(name string) string {
+	return fmt.Sprintf("CN=%s,OU=Users,DC=example,DC=com", strings.ToUpper(name[:1])+name[1:])
+}
+
+func newADHandler(si *standIn, config Config) *Handler {
+	config.Type = ActiveDirectory
+	lh := NewLdapHandler(config)
+	lh.conn = si
+	return lh
+}
+
+// memberNames returns the names of all members of the group as member:group
+func memberNames(lh *Handler, group *Member) (names []string) {
+	for _, ms := range lh.MembershipTree(group) {
+		names = append(names, ms.Member.Name()+":"+ms.MemberOf.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDomainRoot(t *testing.T) {
+	for _, test := range []struct {
+		dn       string
+		expected string
+		fails    bool
+	}{
+		{dn: "CN=dba,OU=Groups,DC=example,DC=com", expected: "dc=example,dc=com"},
+		{dn: "cn=dba,dc=sub,dc=example,dc=com", expected: "dc=sub,dc=example,dc=com"},
+		{dn: "cn=dba,ou=groups,o=example", fails: true},
+		{dn: "not a dn", fails: true},
+	} {
+		t.Run(test.dn, func(t *testing.T) {
+			root, err := domainRoot(test.dn)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error, got %s", root)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if root != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, root)
+			}
+		})
+	}
+}
+
+func TestAttributeValuesRanged(t *testing.T) {
+	si := newADStandIn()
+	lh := newADHandler(si, Config{})
+	entry, err := lh.getEntry("CN=big,OU=Groups,DC=example,DC=com", []string{"member"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name := entry.Attributes[0].Name; name != "member;range=0-499" {
+		t.Fatalf("expected the stand-in to return the first range, got %s", name)
+	}
+	values, err := lh.attributeValues(entry, "member")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(values) != 1200 {
+		t.Fatalf("expected 1200 values, got %d", len(values))
+	}
+	for i, value := range values {
+		if expected := fmt.Sprintf("CN=User%04d,OU=Users,DC=example,DC=com", i); value != expected {
+			t.Fatalf("expected value %d to be %s, got %s", i, expected, value)
+		}
+	}
+	var ranges []string
+	for _, sr := range si.searches {
+		ranges = append(ranges, sr.Attributes...)
+	}
+	expected := []string{"member", "member;range=500-*", "member;range=1000-*"}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected requested attributes %v, got %v", expected, ranges)
+	}
+}
+
+func TestAttributeValuesInvalidRange(t *testing.T) {
+	lh := newADHandler(newADStandIn(), Config{})
+	entry := &ldap.Entry{
+		DN:         "CN=big,OU=Groups,DC=example,DC=com",
+		Attributes: []*ldap.EntryAttribute{ldap.NewEntryAttribute("member;range=0-last", []string{"a"})},
+	}
+	if _, err := lh.attributeValues(entry, "member"); err == nil {
+		t.Errorf("expected an error for an invalid range")
+	}
+}
+
+func TestGetMembersInChain(t *testing.T) {
+	si := newADStandIn()
+	lh := newADHandler(si, Config{})
+	baseGroup, err := lh.GetMembers(GroupSearch{
+		BaseDN: "CN=dba,OU=Groups,DC=example,DC=com",
+		Filter: "(objectClass=*)",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Nested users are direct members, computers are skipped, and names are read from sAMAccountName
+	expected := []string{"alice:dba", "bob:dba"}
+	if names := memberNames(lh, baseGroup); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	// The group is read first, and then its users
+	if len(si.searches) != 2 {
+		t.Fatalf("expected two searches, got %d", len(si.searches))
+	}
+	sr := si.searches[1]
+	if sr.BaseDN != "dc=example,dc=com" || !strings.Contains(sr.Filter, ":"+matchingRuleInChain+":=") {
+		t.Errorf("expected an in chain search in the domain, got %s in %s", sr.Filter, sr.BaseDN)
+	}
+}
+
+func TestGetMembersInChainFilters(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		search   GroupSearch
+		expected []string
+		fails    bool
+	}{
+		{
+			name:     "group filter applies to the group",
+			search:   GroupSearch{BaseDN: "CN=dba,OU=Groups,DC=example,DC=com", Filter: "(objectClass=group)"},
+			expected: []string{"alice:dba", "bob:dba"},
+		},
+		{
+			name: "user filter applies to the users",
+			search: GroupSearch{BaseDN: "CN=dba,OU=Groups,DC=example,DC=com", Filter: "(objectClass=group)",
+				UserFilter: "(sAMAccountName=bob)"},
+			expected: []string{"bob:dba"},
+		},
+		{
+			name: "group that does not match the group filter",
+			search: GroupSearch{BaseDN: "CN=dba,OU=Groups,DC=example,DC=com",
+				Filter: "(objectClass=organizationalUnit)"},
+			fails: true,
+		},
+		{
+			name:   "group that does not exist",
+			search: GroupSearch{BaseDN: "CN=nobody,OU=Groups,DC=example,DC=com", Filter: "(objectClass=group)"},
+			fails:  true,
+		},
+		{
+			name: "no users",
+			search: GroupSearch{BaseDN: "CN=dba,OU=Groups,DC=example,DC=com", Filter: "(objectClass=group)",
+				UserFilter: "(sAMAccountName=nobody)"},
+			fails: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lh := newADHandler(newADStandIn(), Config{})
+			baseGroup, err := lh.GetMembers(test.search)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if names := memberNames(lh, baseGroup); !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestGetMembersWithoutInChain(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		baseDN   string
+		count    int
+		expected []string
+	}{
+		{
+			name:   "nested groups with a cycle",
+			baseDN: "CN=dba,OU=Groups,DC=example,DC=com",
+			// The computer is a user, as far as the object classes tell
+			expected: []string{"alice:dba", "bob:oncall", "build01$:dba", "oncall:dba"},
+		},
+		{name: "ranged members", baseDN: "CN=big,OU=Groups,DC=example,DC=com", count: 1200},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lh := newADHandler(newADStandIn(), Config{DisableInChain: true})
+			baseGroup, err := lh.GetMembers(GroupSearch{BaseDN: test.baseDN, Filter: "(objectClass=group)"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			names := memberNames(lh, baseGroup)
+			if test.expected != nil && !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, names)
+			}
+			if test.count > 0 && len(names) != test.count {
+				t.Errorf("expected %d members, got %d", test.count, len(names))
+			}
+		})
+	}
+}
+
+func TestGetMembersMemberFilters(t *testing.T) {
+	si := newADStandIn()
+	lh := newADHandler(si, Config{})
+	filters := []string{"(sAMAccountName=bob)", "(objectClass=group)"}
+	baseGroup, err := lh.GetMembers(GroupSearch{
+		BaseDN:        "CN=dba,OU=Groups,DC=example,DC=com",
+		Filter:        "(objectClass=group)",
+		MemberFilters: filters,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The group and its users are read first, and then every filter takes one search, rather than one per member
+	if len(si.searches) != 2+len(filters) {
+		t.Fatalf("expected %d searches, got %d", 2+len(filters), len(si.searches))
+	}
+	for _, sr := range si.searches[2:] {
+		if sr.BaseDN != "DC=example,DC=com" {
+			t.Errorf("expected a search below the common suffix of the members, got %s", sr.BaseDN)
+		}
+	}
+	var matching []string
+	for _, member := range baseGroup.reachable() {
+		for _, filter := range filters {
+			matches, err := lh.MatchesFilter(member, filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if matches {
+				matching = append(matching, member.Name()+":"+filter)
+			}
+		}
+	}
+	sort.Strings(matching)
+	expected := []string{"bob:(sAMAccountName=bob)", "dba:(objectClass=group)"}
+	if !reflect.DeepEqual(matching, expected) {
+		t.Errorf("expected %v, got %v", expected, matching)
+	}
+	// The results are kept with the members
+	if len(si.searches) != 2+len(filters) {
+		t.Errorf("expected no searches for cached results, got %d", len(si.searches)-2-len(filters))
+	}
+}
@@ -0,0 +1,185 @@
+package ldap
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// snapshot is the last successful result of GetMembers for a group search, as stored in the snapshot cache
+type snapshot struct {
+	Directory        string           `yaml:"directory"`
+	BaseDN           string           `yaml:"base_dn"`
+	Filter           string           `yaml:"filter"`
+	UserFilter       string           `yaml:"user_filter,omitempty"`
+	MemberAttributes []string         `yaml:"member_attributes"`
+	Timestamp        time.Time        `yaml:"timestamp"`
+	Members          []snapshotMember `yaml:"members"`
+}
+
+type snapshotMember struct {
+	Name      string          `yaml:"name"`
+	Dn        string          `yaml:"dn,omitempty"`
+	MType     MemberType      `yaml:"type"`
+	Disabled  bool            `yaml:"disabled,omitempty"`
+	Expiry    time.Time       `yaml:"expiry,omitempty"`
+	Comment   string          `yaml:"comment,omitempty"`
+	Commented bool            `yaml:"commented,omitempty"`
+	Matches   map[string]bool `yaml:"matches,omitempty"`
+	Children  []string        `yaml:"children,omitempty"`
+}
+
+// newSnapshot returns an empty snapshot for a group search. Directories and ldap-group entries can share a base dn
+// with another filter or other member attributes, so all of these identify the snapshot.
+func (lh *Handler) newSnapshot(search GroupSearch) snapshot {
+	memberAttributes := append([]string{}, lh.memberAttributes(search)...)
+	for i, attribute := range memberAttributes {
+		memberAttributes[i] = strings.ToLower(attribute)
+	}
+	sort.Strings(memberAttributes)
+	return snapshot{
+		Directory:        lh.config.Name,
+		BaseDN:           search.BaseDN,
+		Filter:           search.Filter,
+		UserFilter:       search.UserFilter,
+		MemberAttributes: memberAttributes,
+	}
+}
+
+// sameSearch returns true if both snapshots are (or are for) the same group search
+func (snap snapshot) sameSearch(other snapshot) bool {
+	return snap.Directory == other.Directory && equalDn(snap.BaseDN, other.BaseDN) && snap.Filter == other.Filter &&
+		snap.UserFilter == other.UserFilter &&
+		strings.Join(snap.MemberAttributes, ",") == strings.Join(other.MemberAttributes, ",")
+}
+
+// file returns the path of the snapshot file in cacheDir
+func (snap snapshot) file(cacheDir string) string {
+	key := strings.Join([]string{snap.Directory, dnKey(snap.BaseDN), snap.Filter, snap.UserFilter,
+		strings.Join(snap.MemberAttributes, ",")}, "\n")
+	return filepath.Join(cacheDir, fmt.Sprintf("%x.yaml", sha256.Sum256([]byte(key))))
+}
+
+// writeSnapshot stores the base group with all of its members in the snapshot cache (when configured). Failing to
+// write the snapshot only logs a warning.
+func (lh *Handler) writeSnapshot(search GroupSearch, baseGroup *Member) {
+	if lh.config.CacheDir == "" {
+		return
+	}
+	snap := lh.newSnapshot(search)
+	snap.Timestamp = time.Now().UTC()
+	for _, m := range baseGroup.reachable() {
+		sm := snapshotMember{
+			Name:      m.name,
+			Dn:        m.dn,
+			MType:     m.mType,
+			Disabled:  m.disabled,
+			Expiry:    m.expiry,
+			Comment:   m.comment,
+			Commented: m.commented,
+			Matches:   m.matches,
+		}
+		for name := range m.children {
+			sm.Children = append(sm.Children, name)
+		}
+		sort.Strings(sm.Children)
+		snap.Members = append(snap.Members, sm)
+	}
+	err := lh.saveSnapshot(snap)
+	if err != nil {
+		log.Warnf("could not write ldap snapshot for %s: %v", search.BaseDN, err)
+	}
+}
+
+func (lh *Handler) saveSnapshot(snap snapshot) (err error) {
+	data, err := yaml.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(lh.config.CacheDir, 0700)
+	if err != nil {
+		return err
+	}
+	fileName := snap.file(lh.config.CacheDir)
+	// Write and rename, so that a snapshot is never left half written
+	tmpFile := fileName + ".tmp"
+	err = os.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile, fileName)
+	if err != nil {
+		return err
+	}
+	log.Debugf("wrote ldap snapshot for %s to %s", snap.BaseDN, fileName)
+	return nil
+}
+
+// readSnapshot returns the base group from the snapshot cache, when the directory is unreachable (cause). cause is
+// returned when there is no snapshot, or when it is older than max_cache_age.
+func (lh *Handler) readSnapshot(search GroupSearch, cause error) (baseGroup *Member, err error) {
+	if lh.config.CacheDir == "" || lh.config.MaxCacheAge <= 0 {
+		return nil, cause
+	}
+	wanted := lh.newSnapshot(search)
+	fileName := wanted.file(lh.config.CacheDir)
+	// The snapshot file is written by pgfga itself
+	// #nosec
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%v (and there is no ldap snapshot for %s)", cause, search.BaseDN)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	err = yaml.Unmarshal(data, &snap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap snapshot %s: %v", fileName, err)
+	}
+	if !snap.sameSearch(wanted) || len(snap.Members) == 0 {
+		return nil, fmt.Errorf("ldap snapshot %s is not a snapshot of %s", fileName, search.BaseDN)
+	}
+	age := time.Since(snap.Timestamp)
+	if age > lh.config.MaxCacheAge {
+		return nil, fmt.Errorf("%v (and the ldap snapshot for %s from %s is older than max_cache_age %s)", cause,
+			search.BaseDN, snap.Timestamp.Format(time.RFC3339), lh.config.MaxCacheAge)
+	}
+	log.Warnf("ldap is unreachable (%v), using the snapshot of %s from %s", cause, search.BaseDN,
+		snap.Timestamp.Format(time.RFC3339))
+	// The snapshot is read into a member set of its own, since lh.members can hold members (with their parents) of a
+	// search that failed halfway
+	snapMembers := make(Members)
+	members := make(map[string]*Member)
+	for _, sm := range snap.Members {
+		m, err := snapMembers.GetNamed(sm.Dn, sm.Name, sm.MType)
+		if err != nil {
+			return nil, err
+		}
+		m.disabled = sm.Disabled
+		m.expiry = sm.Expiry
+		m.comment = sm.Comment
+		m.commented = sm.Commented
+		m.matches = sm.Matches
+		members[sm.Name] = m
+	}
+	for _, sm := range snap.Members {
+		for _, childName := range sm.Children {
+			child, exists := members[childName]
+			if !exists {
+				return nil, fmt.Errorf("ldap snapshot %s is inconsistent (%s is missing)", fileName, childName)
+			}
+			child.AddParent(members[sm.Name])
+		}
+	}
+	// The base group is stored first
+	baseGroup = members[snap.Members[0].Name]
+	baseGroup.cachedAt = snap.Timestamp
+	return baseGroup, nil
+}
@@ -0,0 +1,113 @@
+package ldap
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pgvillage-tools/pgfga/pkg/credential"
+)
+
+const cacheBaseDN = "cn=dba,ou=groups,dc=pgfga,dc=org"
+
+func newCacheStandIn() *standIn {
+	si := &standIn{}
+	si.add("uid=alice,cn=dba,ou=groups,dc=pgfga,dc=org", map[string][]string{
+		"objectClass": {"posixAccount"},
+		"uid":         {"alice"},
+	})
+	si.add("uid=bob,cn=dba,ou=groups,dc=pgfga,dc=org", map[string][]string{
+		"objectClass": {"inetOrgPerson"},
+		"uid":         {"bob"},
+	})
+	return si
+}
+
+// newCacheHandler returns a handler for directory name that is connected to si, or cannot connect when si is nil
+func newCacheHandler(name string, cacheDir string, si *standIn) *Handler {
+	lh := NewLdapHandler(Config{
+		Name:        name,
+		Usr:         credential.Credential{Value: "cn=admin,dc=pgfga,dc=org"},
+		Pwd:         credential.Credential{Value: "pGfGa"},
+		Servers:     []string{"ldap://standin"},
+		CacheDir:    cacheDir,
+		MaxCacheAge: time.Hour,
+	})
+	lh.dial = func(string, string, string) (directory, error) {
+		if si == nil {
+			return nil, errors.New("unreachable")
+		}
+		return si, nil
+	}
+	return lh
+}
+
+func TestSnapshotPerSearch(t *testing.T) {
+	cacheDir := t.TempDir()
+	searches := []struct {
+		directory string
+		search    GroupSearch
+		expected  []string
+	}{
+		{
+			directory: "ldap",
+			search:    GroupSearch{BaseDN: cacheBaseDN, Filter: "(objectClass=posixAccount)"},
+			expected:  []string{"alice:dba"},
+		},
+		{
+			directory: "ldap",
+			search:    GroupSearch{BaseDN: cacheBaseDN, Filter: "(objectClass=*)"},
+			expected:  []string{"alice:dba", "bob:dba"},
+		},
+		{
+			directory: "other",
+			search:    GroupSearch{BaseDN: cacheBaseDN, Filter: "(objectClass=inetOrgPerson)"},
+			expected:  []string{"bob:dba"},
+		},
+	}
+	for _, s := range searches {
+		lh := newCacheHandler(s.directory, cacheDir, newCacheStandIn())
+		if _, err := lh.GetMembers(s.search); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, s := range searches {
+		lh := newCacheHandler(s.directory, cacheDir, nil)
+		baseGroup, err := lh.GetMembers(s.search)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if baseGroup.CachedAt().IsZero() {
+			t.Errorf("expected the members of %s in %s to be read from the snapshot", s.search.Filter, s.directory)
+		}
+		if names := memberNames(lh, baseGroup); !reflect.DeepEqual(names, s.expected) {
+			t.Errorf("expected %v for %s in %s, got %v", s.expected, s.search.Filter, s.directory, names)
+		}
+	}
+	lh := newCacheHandler("ldap", cacheDir, nil)
+	_, err := lh.GetMembers(GroupSearch{BaseDN: cacheBaseDN, Filter: "(uid=alice)"})
+	if err == nil {
+		t.Errorf("expected an error for a search without a snapshot")
+	}
+}
+
+func TestSnapshotAfterPartialSearch(t *testing.T) {
+	cacheDir := t.TempDir()
+	search := GroupSearch{BaseDN: cacheBaseDN, Filter: "(objectClass=posixAccount)"}
+	if _, err := newCacheHandler("ldap", cacheDir, newCacheStandIn()).GetMembers(search); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lh := newCacheHandler("ldap", cacheDir, nil)
+	// A search that failed halfway left bob as a member of dba
+	dba, _ := lh.members.GetNamed(cacheBaseDN, "dba", GroupMType)
+	bob, _ := lh.members.GetNamed("uid=bob,cn=dba,ou=groups,dc=pgfga,dc=org", "bob", UserMType)
+	bob.AddParent(dba)
+	baseGroup, err := lh.readSnapshot(search, errors.New("connection lost"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if names, expected := memberNames(lh, baseGroup), []string{"alice:dba"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
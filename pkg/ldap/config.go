@@ -1,16 +1,82 @@
 package ldap
 
+import (
+	"strings"
+	"time"
+
+	"github.com/pgvillage-tools/pgfga/pkg/credential"
+)
+
+const (
+	defaultPageSize      = 500
+	defaultConnTimeout   = 10 * time.Second
+	defaultTimeout       = 60 * time.Second
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = 30 * time.Second
+)
+
 type Config struct {
-	Usr        Credential `yaml:"user"`
-	Pwd        Credential `yaml:"password"`
-	Servers    []string   `yaml:"servers"`
-	MaxRetries int        `yaml:"conn_retries"`
+	// Name is the name of the directory in the pgfga config, which is part of the key of its snapshots
+	Name       string                `yaml:"-"`
+	Usr        credential.Credential `yaml:"user"`
+	Pwd        credential.Credential `yaml:"password"`
+	Servers    []string              `yaml:"servers"`
+	TLS        TLSConfig             `yaml:"tls"`
+	MaxRetries int                   `yaml:"conn_retries"`
+	// ConnTimeout is the timeout for connecting to one server, Timeout is the timeout for every ldap operation
+	ConnTimeout time.Duration `yaml:"conn_timeout"`
+	Timeout     time.Duration `yaml:"timeout"`
+	// RetryDelay is the delay before the second round of connecting to all servers, and is doubled every round
+	// until MaxRetryDelay
+	RetryDelay    time.Duration    `yaml:"retry_delay"`
+	MaxRetryDelay time.Duration    `yaml:"max_retry_delay"`
+	PageSize      uint32           `yaml:"page_size"`
+	Mapping       AttributeMapping `yaml:"attribute_mapping"`
+	// CacheDir holds a snapshot of the members per base dn, which is used when the directory is unreachable and the
+	// snapshot is not older than MaxCacheAge
+	CacheDir    string        `yaml:"cache_dir"`
+	MaxCacheAge time.Duration `yaml:"max_cache_age"`
+	// MaxNestingDepth is the maximum depth of nested groups (0, the default, or -1 means unlimited)
+	MaxNestingDepth int `yaml:"max_nesting_depth"`
+	// Type can be set to "ad" for Active Directory specific behaviour
+	Type           string `yaml:"type"`
+	UserBaseDN     string `yaml:"user_base_dn"`
+	DisableInChain bool   `yaml:"disable_in_chain"`
+	// HbaOptions are the options of the pg_hba.conf ldap lines for the users of this directory (e.a. ldapserver)
+	HbaOptions map[string]string `yaml:"hba_options"`
 }
 
 func (c *Config) SetDefaults() {
 	if c.MaxRetries < 1 {
 		c.MaxRetries = 1
 	}
+	if c.ConnTimeout <= 0 {
+		c.ConnTimeout = defaultConnTimeout
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = defaultRetryDelay
+	}
+	if c.MaxRetryDelay <= 0 {
+		c.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	if c.MaxRetryDelay < c.RetryDelay {
+		c.MaxRetryDelay = c.RetryDelay
+	}
+	if c.PageSize == 0 {
+		c.PageSize = defaultPageSize
+	}
+	if c.IsActiveDirectory() && c.Mapping.NameAttribute == "" {
+		c.Mapping.NameAttribute = "sAMAccountName"
+	}
+	c.Mapping.SetDefaults()
+}
+
+// IsActiveDirectory returns true if the directory is configured to be an Active Directory
+func (c Config) IsActiveDirectory() bool {
+	return strings.EqualFold(c.Type, ActiveDirectory)
 }
 
 func (c Config) User() (user string, err error) {
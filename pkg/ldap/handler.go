@@ -2,86 +2,498 @@ package ldap
 
 import (
 	"fmt"
+	"net"
+	"strings"
+	"time"
+
 	"github.com/go-ldap/ldap/v3"
 )
 
+var (
+	defaultMemberAttributes   = []string{"memberUid"}
+	defaultADMemberAttributes = []string{"member"}
+	// dnMemberAttributes hold the dn of a member (user or nested group), where other member attributes (like
+	// memberUid) hold the name of a user
+	dnMemberAttributes = map[string]bool{
+		"member":       true,
+		"uniquemember": true,
+	}
+)
+
+// GroupSearch defines where and how the members of a group are searched for in the directory
+type GroupSearch struct {
+	BaseDN           string
+	Filter           string
+	MemberAttributes []string
+	// MemberFilters are evaluated for all members (see matchFilters), so that the results are cached too
+	MemberFilters []string
+	// UserFilter is added to the search for users in Active Directory in chain mode, where Filter applies to the
+	// group itself
+	UserFilter string
+}
+
+// directory holds the operations pgfga runs on an ldap connection, so that a stand-in can be used in tests
+type directory interface {
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	SearchWithPaging(searchRequest *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error)
+	IsClosing() bool
+	Close()
+}
+
 type Handler struct {
 	config  Config
-	conn    *ldap.Conn
+	conn    directory
 	members Members
+	// dial connects and binds to a server (connectServer, unless replaced by a stand-in in tests)
+	dial func(server string, user string, pwd string) (conn directory, err error)
+	// unreachable holds the error of connecting, when none of the servers could be connected to
+	unreachable error
 }
 
 func NewLdapHandler(config Config) (lh *Handler) {
 	config.SetDefaults()
-	return &Handler{
+	lh = &Handler{
 		config:  config,
 		members: make(Members),
 	}
+	lh.dial = lh.connectServer
+	return lh
 }
 
+// Connect connects to the first ldap server that is available and accepts the bind. All servers are tried in every
+// round, with an exponential backoff between rounds. An existing connection is reused, unless it was lost.
 func (lh *Handler) Connect() (err error) {
 	if lh.conn != nil {
-		return nil
+		if !lh.conn.IsClosing() {
+			return nil
+		}
+		log.Infof("ldap connection was lost, reconnecting")
+		lh.conn.Close()
+		lh.conn = nil
 	}
+	var user, pwd string
+	if !lh.config.TLS.SaslExternal {
+		// With SASL EXTERNAL, the client certificate is the identity, and user and password are not required
+		user, err = lh.config.User()
+		if err != nil {
+			return err
+		}
+		pwd, err = lh.config.Password()
+		if err != nil {
+			return err
+		}
+	}
+	delay := lh.config.RetryDelay
+	var lastErr error
 	for i := 0; i < lh.config.MaxRetries; i++ {
+		if i > 0 {
+			log.Infof("none of the ldap servers are available, retrying in %s", delay)
+			time.Sleep(delay)
+			delay *= 2
+			if delay > lh.config.MaxRetryDelay {
+				delay = lh.config.MaxRetryDelay
+			}
+		}
 		for _, server := range lh.config.Servers {
-			conn, err := ldap.DialURL(server)
+			conn, err := lh.dial(server, user, pwd)
 			if err != nil {
+				log.Warnf("could not connect to ldap server %s: %v", server, err)
+				lastErr = err
 				continue
 			}
-			user, err := lh.config.User()
-			if err != nil {
-				return err
-			}
-			pwd, err := lh.config.Password()
-			if err != nil {
-				return err
-			}
-			err = conn.Bind(user, pwd)
-			if err != nil {
-				return err
-			}
+			log.Debugf("connected to ldap server %s", server)
 			lh.conn = conn
 			return nil
 		}
 	}
-	return fmt.Errorf("none of the ldap servers are available")
+	return fmt.Errorf("none of the ldap servers are available (last error: %v)", lastErr)
 }
 
-func (lh Handler) GetMembers(baseDN string, filter string) (baseGroup *Member, err error) {
+// connectServer dials a server, sets up TLS and binds
+func (lh *Handler) connectServer(server string, user string, pwd string) (conn directory, err error) {
+	tlsConfig, err := lh.config.TLS.tlsConfig(server)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ldap.DialURL(server, ldap.DialWithTLSConfig(tlsConfig),
+		ldap.DialWithDialer(&net.Dialer{Timeout: lh.config.ConnTimeout}))
+	if err != nil {
+		return nil, err
+	}
+	c.SetTimeout(lh.config.Timeout)
+	if lh.config.TLS.StartTLS && !strings.HasPrefix(strings.ToLower(server), "ldaps://") {
+		err = c.StartTLS(tlsConfig)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("StartTLS failed: %v", err)
+		}
+	}
+	err = lh.bind(c, server, user, pwd)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// bind authenticates with SASL EXTERNAL when configured, and with user and password otherwise
+func (lh *Handler) bind(conn *ldap.Conn, server string, user string, pwd string) (err error) {
+	if lh.config.TLS.SaslExternal {
+		return conn.ExternalBind()
+	}
+	if pwd != "" && !lh.config.TLS.usesTLS(server) {
+		log.Warnf("binding to %s with a password over an unencrypted connection (use ldaps:// or tls.start_tls)",
+			server)
+	}
+	return conn.Bind(user, pwd)
+}
+
+// withReconnect runs an ldap operation, and runs it once more on a new connection when the connection was lost
+func (lh *Handler) withReconnect(operation func() error) (err error) {
+	err = operation()
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		return err
+	}
+	log.Warnf("ldap operation failed with a network error, reconnecting: %v", err)
+	lh.conn.Close()
+	lh.conn = nil
 	err = lh.Connect()
+	if err != nil {
+		return err
+	}
+	return operation()
+}
+
+func (lh *Handler) memberAttributes(search GroupSearch) (attributes []string) {
+	if len(search.MemberAttributes) > 0 {
+		return search.MemberAttributes
+	}
+	if lh.config.IsActiveDirectory() {
+		return defaultADMemberAttributes
+	}
+	return defaultMemberAttributes
+}
+
+// GroupName returns the role name for the group with this dn
+func (lh *Handler) GroupName(dn string) (name string, err error) {
+	return lh.config.Mapping.GroupName(dn)
+}
+
+// GetMembers returns the base group with all of its (nested) members. When the directory is unreachable, the members
+// are read from the snapshot cache (when it is configured and recent enough), and the base group is marked as cached.
+func (lh *Handler) GetMembers(search GroupSearch) (baseGroup *Member, err error) {
+	if lh.unreachable == nil {
+		err = lh.Connect()
+		if err != nil {
+			lh.unreachable = err
+		}
+	}
+	if lh.unreachable != nil {
+		return lh.readSnapshot(search, lh.unreachable)
+	}
+	baseGroup, err = lh.getMembers(search)
+	if err != nil && (lh.conn == nil || ldap.IsErrorWithCode(err, ldap.ErrorNetwork)) {
+		// The connection was lost, and reconnecting failed
+		lh.unreachable = err
+		return lh.readSnapshot(search, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = lh.matchFilters(baseGroup.reachable(), search.MemberFilters)
 	if err != nil {
 		return nil, err
 	}
-	baseGroup, err = lh.members.GetById(baseDN, true)
+	lh.writeSnapshot(search, baseGroup)
+	return baseGroup, nil
+}
+
+func (lh *Handler) getMembers(search GroupSearch) (baseGroup *Member, err error) {
+	mapping := lh.config.Mapping
+	baseName, err := mapping.GroupName(search.BaseDN)
 	if err != nil {
 		return nil, err
 	}
-	searchRequest := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, 0, false,
-		filter, []string{"dn", "cn", "memberUid"}, nil)
-	sr, err := lh.conn.Search(searchRequest)
+	baseGroup, err = lh.members.GetNamed(search.BaseDN, baseName, GroupMType)
+	if err != nil {
+		return nil, err
+	}
+	if lh.config.IsActiveDirectory() && !lh.config.DisableInChain {
+		return baseGroup, lh.getInChainUsers(baseGroup, search)
+	}
+	memberAttributes := lh.memberAttributes(search)
+	attributes := append(append([]string{"dn", "cn", "objectClass"}, mapping.userAttributes()...), memberAttributes...)
+	searchRequest := ldap.NewSearchRequest(search.BaseDN, ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, 0, false,
+		search.Filter, attributes, nil)
+	sr, err := lh.search(searchRequest)
 	if err != nil {
 		return nil, err
 	}
 
+	visited := make(map[string]bool)
+	for _, entry := range sr.Entries {
+		visited[dnKey(entry.DN)] = true
+	}
 	for _, entry := range sr.Entries {
-		group, err := lh.members.GetById(entry.DN, true)
+		if mapping.MemberType(entry, memberAttributes) == UserMType {
+			// A user that lives in the subtree of the base group is a member of the base group
+			err = lh.addUserEntry(baseGroup, entry)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		group, err := lh.groupFromEntry(entry)
 		if err != nil {
 			return nil, err
 		}
 		group.AddParent(baseGroup)
-		for _, memberUid := range entry.GetAttributeValues("memberUid") {
-			member, err := lh.members.GetById(memberUid, true)
+		err = lh.addEntryMembers(group, entry, memberAttributes, visited)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return baseGroup, nil
+}
+
+// ManagesExpiry returns true if the expiry of users is read from the directory
+func (lh *Handler) ManagesExpiry() bool {
+	return len(lh.config.Mapping.ExpiryAttributes) > 0
+}
+
+// MembershipTree returns all (nested) memberships of a group, up to the maximum nesting depth (when configured)
+func (lh *Handler) MembershipTree(group *Member) (mss Memberships) {
+	if lh.config.MaxNestingDepth < 0 {
+		return group.MembershipTree(0)
+	}
+	return group.MembershipTree(lh.config.MaxNestingDepth)
+}
+
+func (lh *Handler) groupFromEntry(entry *ldap.Entry) (group *Member, err error) {
+	name, err := lh.config.Mapping.GroupName(entry.DN)
+	if err != nil {
+		return nil, err
+	}
+	group, err = lh.members.GetNamed(entry.DN, name, GroupMType)
+	if err != nil {
+		return nil, err
+	}
+	lh.setComment(group, entry)
+	return group, nil
+}
+
+// setComment sets the comment of the member from the comment attributes of its entry
+func (lh *Handler) setComment(member *Member, entry *ldap.Entry) {
+	if len(lh.config.Mapping.CommentAttributes) == 0 {
+		return
+	}
+	member.comment = lh.config.Mapping.Comment(entry)
+	member.commented = true
+}
+
+func (lh *Handler) addUserEntry(group *Member, entry *ldap.Entry) (err error) {
+	name, err := lh.config.Mapping.UserName(entry)
+	if err != nil {
+		return err
+	}
+	member, err := lh.members.GetNamed(entry.DN, name, UserMType)
+	if err != nil {
+		return err
+	}
+	member.disabled, member.expiry, err = lh.config.Mapping.AccountStatus(entry)
+	if err != nil {
+		return err
+	}
+	lh.setComment(member, entry)
+	member.AddParent(group)
+	log.Debugf("%s: %v", member.Name(), group.Name())
+	return nil
+}
+
+// addEntryMembers adds all members from the member attributes of a group entry to the group
+func (lh *Handler) addEntryMembers(group *Member, entry *ldap.Entry, memberAttributes []string,
+	visited map[string]bool) (err error) {
+	for _, attribute := range memberAttributes {
+		values, err := lh.attributeValues(entry, attribute)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			if dnMemberAttributes[strings.ToLower(attribute)] {
+				err = lh.addDnMember(group, value, memberAttributes, visited)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			name, err := lh.config.Mapping.Transform.Apply(value)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			member.AddParent(group)
-			err = member.SetMType(UserMType)
+			member, err := lh.members.GetNamed("", name, UserMType)
 			if err != nil {
-				return nil, err
+				return err
 			}
+			member.AddParent(group)
 			log.Debugf("%s: %v", member.Name(), group.Name())
 		}
 	}
-	return baseGroup, nil
+	return nil
+}
+
+// addDnMember looks up the entry of a member dn, and adds it to the group as a user, or as a nested group (with all
+// of its members)
+func (lh *Handler) addDnMember(group *Member, dn string, memberAttributes []string, visited map[string]bool) (
+	err error) {
+	mapping := lh.config.Mapping
+	// uniqueMember values can have an optional uid suffix (e.a. cn=me,dc=org#'0101'B)
+	dn = strings.SplitN(dn, "#", 2)[0]
+	entry, err := lh.getEntry(dn, append(append([]string{"objectClass"}, mapping.userAttributes()...),
+		memberAttributes...))
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		log.Warnf("member %s of group %s does not exist", dn, group.Name())
+		return nil
+	}
+	switch mapping.MemberType(entry, memberAttributes) {
+	case GroupMType:
+		subGroup, err := lh.groupFromEntry(entry)
+		if err != nil {
+			return err
+		}
+		subGroup.AddParent(group)
+		if visited[dnKey(entry.DN)] {
+			return nil
+		}
+		visited[dnKey(entry.DN)] = true
+		return lh.addEntryMembers(subGroup, entry, memberAttributes, visited)
+	case UserMType:
+		return lh.addUserEntry(group, entry)
+	default:
+		log.Warnf("skipping member %s of group %s (cannot determine if it is a user or a group from its "+
+			"object classes)", dn, group.Name())
+		return nil
+	}
+}
+
+// search runs a search with the simple paged results control, so that all entries are returned, also when there are
+// more than the size limit of the server. A partial result is never returned.
+func (lh *Handler) search(searchRequest *ldap.SearchRequest) (sr *ldap.SearchResult, err error) {
+	err = lh.withReconnect(func() (err error) {
+		// SearchWithPaging adds a paging control with the cookie of the current connection to the request. Every
+		// attempt uses a fresh copy of the request, so that a retry on a new connection starts at the first page.
+		request := *searchRequest
+		request.Controls = append([]ldap.Control{}, searchRequest.Controls...)
+		sr, err = lh.conn.SearchWithPaging(&request, lh.config.PageSize)
+		return err
+	})
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		var count int
+		if sr != nil {
+			count = len(sr.Entries)
+		}
+		return nil, fmt.Errorf("ldap search in %s with filter %s hit a size limit after %d entries, refusing to sync "+
+			"a partial result (check page_size and the size limits of the ldap server): %v", searchRequest.BaseDN,
+			searchRequest.Filter, count, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// MatchesFilter returns true if the entry of the member matches the ldap filter. Members without an entry (e.a.
+// members from a memberUid attribute) never match.
+func (lh *Handler) MatchesFilter(member *Member, filter string) (matches bool, err error) {
+	if member.Dn() == "" {
+		return false, nil
+	}
+	if matches, exists := member.matches[filter]; exists {
+		return matches, nil
+	}
+	if lh.unreachable != nil {
+		return false, fmt.Errorf("cannot match %s with filter %s: %v", member.Dn(), filter, lh.unreachable)
+	}
+	err = lh.Connect()
+	if err != nil {
+		return false, err
+	}
+	entry, err := lh.getFilteredEntry(member.Dn(), filter, []string{"dn"})
+	if err != nil {
+		return false, err
+	}
+	member.setMatch(filter, entry != nil)
+	return entry != nil, nil
+}
+
+// matchFilters evaluates ldap filters for a set of members, and keeps the results with the members (see
+// MatchesFilter). Every filter takes one subtree search below the common suffix of the members, rather than a search
+// per member. Members from trees without a common suffix are matched one by one.
+func (lh *Handler) matchFilters(members []*Member, filters []string) (err error) {
+	for _, filter := range filters {
+		var pending []*Member
+		var dns []string
+		for _, member := range members {
+			if _, exists := member.matches[filter]; exists || member.Dn() == "" {
+				continue
+			}
+			pending = append(pending, member)
+			dns = append(dns, member.Dn())
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		baseDN := commonSuffix(dns)
+		if baseDN == "" {
+			for _, member := range pending {
+				_, err = lh.MatchesFilter(member, filter)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		searchRequest := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, 0, false,
+			filter, []string{"dn"}, nil)
+		sr, err := lh.search(searchRequest)
+		if err != nil {
+			return err
+		}
+		matching := make(map[string]bool)
+		for _, entry := range sr.Entries {
+			matching[dnKey(entry.DN)] = true
+		}
+		for _, member := range pending {
+			member.setMatch(filter, matching[dnKey(member.Dn())])
+		}
+	}
+	return nil
+}
+
+// getEntry returns the entry with the dn, or nil if it does not exist
+func (lh *Handler) getEntry(dn string, attributes []string) (entry *ldap.Entry, err error) {
+	return lh.getFilteredEntry(dn, "(objectClass=*)", attributes)
+}
+
+// getFilteredEntry returns the entry with the dn, or nil if it does not exist or does not match the filter
+func (lh *Handler) getFilteredEntry(dn string, filter string, attributes []string) (entry *ldap.Entry, err error) {
+	searchRequest := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.DerefAlways, 0, 0, false,
+		filter, attributes, nil)
+	var sr *ldap.SearchResult
+	err = lh.withReconnect(func() (err error) {
+		sr, err = lh.conn.Search(searchRequest)
+		return err
+	})
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, nil
+	}
+	return sr.Entries[0], nil
 }
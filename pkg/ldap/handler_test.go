@@ -0,0 +1,98 @@
+package ldap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pgvillage-tools/pgfga/pkg/credential"
+)
+
+func TestConnectCredentials(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		usr   string
+		pwd   string
+		tls   TLSConfig
+		fails bool
+	}{
+		{name: "simple bind", usr: "cn=admin,dc=pgfga,dc=org", pwd: "pGfGa"},
+		{name: "simple bind without credentials", fails: true},
+		{
+			name: "sasl external without credentials",
+			tls:  TLSConfig{SaslExternal: true, CertFile: "client.crt", KeyFile: "client.key"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lh := NewLdapHandler(Config{
+				Usr:     credential.Credential{Value: test.usr},
+				Pwd:     credential.Credential{Value: test.pwd},
+				Servers: []string{"ldaps://standin"},
+				TLS:     test.tls,
+			})
+			var boundAs string
+			lh.dial = func(server string, user string, pwd string) (directory, error) {
+				boundAs = user
+				if pwd != test.pwd {
+					return nil, fmt.Errorf("expected password %s, got %s", test.pwd, pwd)
+				}
+				return &standIn{}, nil
+			}
+			err := lh.Connect()
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if boundAs != test.usr {
+				t.Errorf("expected a bind as %q, got %q", test.usr, boundAs)
+			}
+		})
+	}
+}
+
+func TestSearchRetryStartsAtFirstPage(t *testing.T) {
+	si := &standIn{dropAfterPages: 1}
+	for i := 0; i < 25; i++ {
+		si.add(fmt.Sprintf("uid=user%02d,ou=users,dc=pgfga,dc=org", i), map[string][]string{
+			"objectClass": {"posixAccount"},
+		})
+	}
+	lh := NewLdapHandler(Config{
+		Usr:      credential.Credential{Value: "cn=admin,dc=pgfga,dc=org"},
+		Pwd:      credential.Credential{Value: "pGfGa"},
+		Servers:  []string{"ldap://standin"},
+		PageSize: 10,
+	})
+	lh.conn = si
+	reconnected := si.reconnect()
+	lh.dial = func(string, string, string) (directory, error) {
+		return reconnected, nil
+	}
+	searchRequest := ldap.NewSearchRequest("ou=users,dc=pgfga,dc=org", ldap.ScopeWholeSubtree, ldap.DerefAlways, 0,
+		0, false, "(objectClass=posixAccount)", []string{"dn"}, nil)
+	sr, err := lh.search(searchRequest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !si.closed || lh.conn != reconnected {
+		t.Fatalf("expected the search to be retried on a new connection")
+	}
+	if len(sr.Entries) != 25 {
+		t.Errorf("expected all 25 entries, got %d", len(sr.Entries))
+	}
+	seen := make(map[string]bool)
+	for _, entry := range sr.Entries {
+		if seen[entry.DN] {
+			t.Errorf("%s is returned more than once", entry.DN)
+		}
+		seen[entry.DN] = true
+	}
+	if len(searchRequest.Controls) != 0 {
+		t.Errorf("expected the search request to be left unchanged, got controls %v", searchRequest.Controls)
+	}
+}
@@ -0,0 +1,135 @@
+package ldap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	defaultUserObjectClasses  = []string{"posixAccount", "inetOrgPerson", "person", "account", "user"}
+	defaultGroupObjectClasses = []string{"groupOfNames", "groupOfUniqueNames", "posixGroup", "group"}
+)
+
+// AttributeMapping defines how entries in the directory are mapped to roles in postgres
+type AttributeMapping struct {
+	NameAttribute      string        `yaml:"name_attribute"`
+	UserObjectClasses  []string      `yaml:"user_object_classes"`
+	GroupObjectClasses []string      `yaml:"group_object_classes"`
+	Transform          NameTransform `yaml:"name_transform"`
+	// StatusAttributes tell if the account of a user is disabled, and ExpiryAttributes when it expires
+	StatusAttributes []string `yaml:"status_attributes"`
+	ExpiryAttributes []string `yaml:"expiry_attributes"`
+	// CommentAttributes are copied into the comment of the role
+	CommentAttributes []string `yaml:"comment_attributes"`
+}
+
+// SetDefaults sets defaults for all options that are not set
+func (am *AttributeMapping) SetDefaults() {
+	if am.NameAttribute == "" {
+		am.NameAttribute = "uid"
+	}
+	if len(am.UserObjectClasses) == 0 {
+		am.UserObjectClasses = defaultUserObjectClasses
+	}
+	if len(am.GroupObjectClasses) == 0 {
+		am.GroupObjectClasses = defaultGroupObjectClasses
+	}
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemberType derives the type of an entry from its object classes, or from having member attributes.
+// UnknownMType is returned when neither tells what the entry is.
+func (am AttributeMapping) MemberType(entry *ldap.Entry, memberAttributes []string) (mt MemberType) {
+	objectClasses := entry.GetEqualFoldAttributeValues("objectClass")
+	for _, objectClass := range objectClasses {
+		if containsFold(am.GroupObjectClasses, objectClass) {
+			return GroupMType
+		}
+	}
+	for _, objectClass := range objectClasses {
+		if containsFold(am.UserObjectClasses, objectClass) {
+			return UserMType
+		}
+	}
+	for _, attribute := range memberAttributes {
+		if len(entry.GetEqualFoldAttributeValues(attribute)) > 0 {
+			return GroupMType
+		}
+	}
+	return UnknownMType
+}
+
+// UserName returns the (transformed) role name for a user entry, which is read from the name attribute, and falls
+// back to the value of the first RDN of the dn
+func (am AttributeMapping) UserName(entry *ldap.Entry) (name string, err error) {
+	name = entry.GetEqualFoldAttributeValue(am.NameAttribute)
+	if name == "" {
+		m, err := NewMember(entry.DN)
+		if err != nil {
+			return "", err
+		}
+		name = m.Name()
+	}
+	return am.Transform.Apply(name)
+}
+
+// GroupName returns the (transformed) role name for a group, which is the value of the first RDN of the dn
+func (am AttributeMapping) GroupName(dn string) (name string, err error) {
+	m, err := NewMember(dn)
+	if err != nil {
+		return "", err
+	}
+	return am.Transform.Apply(m.Name())
+}
+
+// Comment returns the comment for the role of an entry, which holds the values of the comment attributes
+// (e.a. displayName: Jane Doe; mail: jane.doe@example.com)
+func (am AttributeMapping) Comment(entry *ldap.Entry) (comment string) {
+	var parts []string
+	for _, attribute := range am.CommentAttributes {
+		values := entry.GetEqualFoldAttributeValues(attribute)
+		if len(values) == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", attribute, strings.Join(values, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// NameTransform defines how names in the directory are transformed into role names
+type NameTransform struct {
+	Lowercase   bool   `yaml:"lowercase"`
+	Prefix      string `yaml:"prefix"`
+	Suffix      string `yaml:"suffix"`
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+	re          *regexp.Regexp
+}
+
+// Apply returns the transformed name. The regex is applied first, then lowercase, and then prefix and suffix.
+func (nt *NameTransform) Apply(name string) (transformed string, err error) {
+	if nt.Regex != "" {
+		if nt.re == nil {
+			nt.re, err = regexp.Compile(nt.Regex)
+			if err != nil {
+				return "", fmt.Errorf("invalid name_transform regex %s: %v", nt.Regex, err)
+			}
+		}
+		name = nt.re.ReplaceAllString(name, nt.Replacement)
+	}
+	if nt.Lowercase {
+		name = strings.ToLower(name)
+	}
+	return nt.Prefix + name + nt.Suffix, nil
+}
@@ -2,8 +2,12 @@ package ldap
 
 import (
 	"errors"
-	"regexp"
+	"fmt"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
 )
 
 type MemberType int
@@ -21,16 +25,129 @@ type Member struct {
 	mType    MemberType
 	parents  Members
 	children Members
+	// disabled and expiry are read from the status and expiry attributes of a user entry
+	disabled bool
+	expiry   time.Time
+	// comment is read from the comment attributes, and commented is true when it was read from the entry
+	comment   string
+	commented bool
+	// matches holds the results of ldap filters for the entry (see Handler.MatchesFilter)
+	matches map[string]bool
+	// cachedAt is set for a base group that was read from the snapshot cache
+	cachedAt time.Time
+}
+
+// parseDn parses a dn as defined in RFC 4514, including multi-valued RDNs and escaped characters
+func parseDn(dn string) (parsed *ldap.DN, err error) {
+	parsed, err = ldap.ParseDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed.RDNs) == 0 {
+		return nil, fmt.Errorf("empty dn")
+	}
+	for _, rdn := range parsed.RDNs {
+		if len(rdn.Attributes) == 0 {
+			return nil, fmt.Errorf("dn %s has an empty RDN", dn)
+		}
+		for _, attr := range rdn.Attributes {
+			if attr.Type == "" {
+				return nil, fmt.Errorf("dn %s has an RDN without attribute type", dn)
+			}
+		}
+	}
+	return parsed, nil
 }
 
 func validDn(dn string) bool {
-	var validDn = regexp.MustCompile(`^([a-zA-Z]+=[a-zA-Z0-9]+,)*[a-zA-Z]+=[a-zA-Z0-9]+$`)
-	return validDn.MatchString(dn)
+	_, err := parseDn(dn)
+	return err == nil
 }
 
 func validLdapPair(pair string) (isValid bool) {
-	var validPair = regexp.MustCompile(`^[a-zA-Z]+=[a-zA-Z0-9]+$`)
-	return validPair.MatchString(pair)
+	parsed, err := parseDn(pair)
+	return err == nil && len(parsed.RDNs) == 1
+}
+
+// escapeDnValue escapes an attribute value for use in a dn (RFC 4514, section 2.4)
+func escapeDnValue(value string) (escaped string) {
+	var b strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(`"+,;<>\=`, c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		case c == 0:
+			b.WriteString("\\00")
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// rdnString returns the string representation of an RDN. With fold, attribute types and values are lowercased and
+// the attributes of a multi-valued RDN are sorted, so that equal RDNs have an equal representation.
+func rdnString(rdn *ldap.RelativeDN, fold bool) (pair string) {
+	var attrs []string
+	for _, attr := range rdn.Attributes {
+		attrType, value := attr.Type, attr.Value
+		if fold {
+			attrType, value = strings.ToLower(attrType), strings.ToLower(value)
+		}
+		attrs = append(attrs, attrType+"="+escapeDnValue(value))
+	}
+	if fold {
+		sort.Strings(attrs)
+	}
+	return strings.Join(attrs, "+")
+}
+
+// dnKey returns a normalized representation of a dn, which is equal for dns that are equal (case-insensitive)
+func dnKey(dn string) (key string) {
+	parsed, err := parseDn(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	var rdns []string
+	for _, rdn := range parsed.RDNs {
+		rdns = append(rdns, rdnString(rdn, true))
+	}
+	return strings.Join(rdns, ",")
+}
+
+// commonSuffix returns the longest dn that all dns are equal to, or live in the subtree of. It returns an empty string
+// when the dns have nothing in common.
+func commonSuffix(dns []string) (suffix string) {
+	var common []*ldap.RelativeDN
+	for i, dn := range dns {
+		parsed, err := parseDn(dn)
+		if err != nil {
+			return ""
+		}
+		if i == 0 {
+			common = parsed.RDNs
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(parsed.RDNs) && rdnString(common[len(common)-1-n], true) ==
+			rdnString(parsed.RDNs[len(parsed.RDNs)-1-n], true) {
+			n++
+		}
+		common = common[len(common)-n:]
+	}
+	var rdns []string
+	for _, rdn := range common {
+		rdns = append(rdns, rdnString(rdn, false))
+	}
+	return strings.Join(rdns, ",")
+}
+
+// equalDn returns true if both dns are equal (case-insensitive)
+func equalDn(dn string, other string) bool {
+	return dnKey(dn) == dnKey(other)
 }
 
 func NewMember(Id string) (m *Member, err error) {
@@ -42,7 +159,7 @@ func NewMember(Id string) (m *Member, err error) {
 }
 
 func GetMemberType(key string) (mt MemberType) {
-	switch key {
+	switch strings.ToLower(key) {
 	case "cn":
 		return GroupMType
 	case "uid":
@@ -57,34 +174,36 @@ func (m *Member) SetFromId(Id string) (err error) {
 	if m.dn != "" {
 		return nil
 	}
-	if validDn(Id) {
-		pair := strings.Split(Id, ",")[0]
-		if m.pair != "" && m.pair != pair {
+	if parsed, err := parseDn(Id); err == nil {
+		rdn := parsed.RDNs[0]
+		pair := rdnString(rdn, false)
+		if m.pair != "" && !strings.EqualFold(m.pair, pair) {
 			return errors.New("trying to set dn, while pair is already set differently")
 		}
-		key := strings.Split(pair, "=")[0]
-		name := strings.Split(pair, "=")[1]
-		if m.name != "" && m.name != name {
+		// For a multi-valued RDN (e.a. cn=Jean+uid=jdoe), the first value is the name
+		key := rdn.Attributes[0].Type
+		name := rdn.Attributes[0].Value
+		if m.name != "" && !strings.EqualFold(m.name, name) {
 			return errors.New("trying to set dn, while name is already set differently")
 		}
 		m.dn = Id
 		m.pair = pair
 		m.name = name
 		m.mType = GetMemberType(key)
-		return
+		return nil
 	}
 	if m.pair != "" {
 		return nil
 	}
 	if validLdapPair(Id) {
-		key := strings.Split(Id, "=")[0]
-		name := strings.Split(Id, "=")[1]
-		if m.name != "" && m.name != name {
-			return errors.New("trying to set pai, while name is already set differently")
+		parsed, _ := parseDn(Id)
+		attr := parsed.RDNs[0].Attributes[0]
+		if m.name != "" && !strings.EqualFold(m.name, attr.Value) {
+			return errors.New("trying to set pair, while name is already set differently")
 		}
 		m.pair = Id
-		m.name = name
-		m.mType = GetMemberType(key)
+		m.name = attr.Value
+		m.mType = GetMemberType(attr.Type)
 	}
 	if m.name != "" {
 		return nil
@@ -109,6 +228,27 @@ func (m *Member) GetMType() (mt MemberType) {
 	return m.mType
 }
 
+// Disabled returns true if the account of the user is disabled in the directory
+func (m *Member) Disabled() bool {
+	return m.disabled
+}
+
+// Expiry returns when the account of the user expires in the directory, or the zero time if it never expires
+func (m *Member) Expiry() (expiry time.Time) {
+	return m.expiry
+}
+
+// Comment returns the comment for the role of the member, and if it was read from the directory
+func (m *Member) Comment() (comment string, commented bool) {
+	return m.comment, m.commented
+}
+
+// CachedAt returns when the snapshot was taken for a base group that was read from the snapshot cache, or the zero
+// time when it was read from the directory
+func (m *Member) CachedAt() (cachedAt time.Time) {
+	return m.cachedAt
+}
+
 func (m *Member) Name() (name string) {
 	return m.name
 }
@@ -122,7 +262,7 @@ func (m *Member) Dn() (dn string) {
 }
 
 func (m *Member) AddParent(p *Member) {
-	if m.dn == p.dn {
+	if m.dn == p.dn || (m.dn != "" && equalDn(m.dn, p.dn)) {
 		// This is me, myself and I. Skipping.
 		return
 	}
@@ -141,21 +281,128 @@ type Membership struct {
 
 type Memberships []Membership
 
-func (m *Member) MembershipTree() (mss Memberships) {
-	for _, member := range m.children {
+// MembershipTree returns all (nested) memberships of the group. Every membership is returned once, also when it is
+// reached through several paths. Cycles (e.a. group A contains B and B contains A) are skipped with a warning, and
+// so are members of groups nested deeper than maxDepth (0 means unlimited).
+func (m *Member) MembershipTree(maxDepth int) (mss Memberships) {
+	mt := membershipTree{
+		maxDepth:   maxDepth,
+		seen:       make(map[Membership]bool),
+		expandedAt: make(map[*Member]int),
+	}
+	mt.walk(m, []*Member{m})
+	return mt.memberships
+}
+
+type membershipTree struct {
+	maxDepth    int
+	memberships Memberships
+	seen        map[Membership]bool
+	// expandedAt holds the depth at which the members of a group are added, so that they are only added again when
+	// the group is reached at a lower depth
+	expandedAt map[*Member]int
+}
+
+func (mt *membershipTree) walk(group *Member, path []*Member) {
+	depth := len(path)
+	if previous, exists := mt.expandedAt[group]; exists && previous <= depth {
+		return
+	}
+	mt.expandedAt[group] = depth
+	var names []string
+	for name := range group.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		member := group.children[name]
+		if onPath(path, member) {
+			log.Warnf("skipping membership of %s in %s, which would close a membership cycle (%s)", member.Name(),
+				group.Name(), pathNames(append(path, member)))
+			continue
+		}
 		ms := Membership{
 			Member:   member,
-			MemberOf: m,
+			MemberOf: group,
 		}
-		mss = append(mss, ms)
-		subMss := member.MembershipTree()
-		mss = append(mss, subMss...)
+		if !mt.seen[ms] {
+			mt.seen[ms] = true
+			mt.memberships = append(mt.memberships, ms)
+		}
+		if len(member.children) == 0 {
+			continue
+		}
+		if mt.maxDepth > 0 && depth >= mt.maxDepth {
+			log.Warnf("skipping members of %s, which is nested deeper than the maximum nesting depth of %d (%s)",
+				member.Name(), mt.maxDepth, pathNames(append(path, member)))
+			continue
+		}
+		mt.walk(member, append(path, member))
 	}
-	return mss
+}
+
+func onPath(path []*Member, m *Member) bool {
+	for _, p := range path {
+		if p == m {
+			return true
+		}
+	}
+	return false
+}
+
+func pathNames(path []*Member) string {
+	var names []string
+	for _, m := range path {
+		names = append(names, m.Name())
+	}
+	return strings.Join(names, " -> ")
+}
+
+// reachable returns all (nested) members of the group, including the group itself
+// setMatch keeps the result of an ldap filter for the entry of the member
+func (m *Member) setMatch(filter string, matches bool) {
+	if m.matches == nil {
+		m.matches = make(map[string]bool)
+	}
+	m.matches[filter] = matches
+}
+
+func (m *Member) reachable() (members []*Member) {
+	visited := map[*Member]bool{m: true}
+	members = []*Member{m}
+	for i := 0; i < len(members); i++ {
+		for _, child := range members[i].children {
+			if !visited[child] {
+				visited[child] = true
+				members = append(members, child)
+			}
+		}
+	}
+	return members
 }
 
 type Members map[string]*Member
 
+// GetNamed returns the member with this name, and adds it when missing.
+// Other than GetById, the name is not derived from the dn, so it can be set from an attribute of the entry.
+func (ms Members) GetNamed(dn string, name string, mt MemberType) (m *Member, err error) {
+	m, exists := ms[name]
+	if !exists {
+		m = &Member{
+			name:     name,
+			mType:    mt,
+			parents:  make(Members),
+			children: make(Members),
+		}
+		ms[name] = m
+	}
+	if m.dn == "" && dn != "" {
+		m.dn = dn
+		ms[dnKey(dn)] = m
+	}
+	return m, m.SetMType(mt)
+}
+
 func (ms Members) GetById(Id string, AddWhenMissing bool) (m *Member, err error) {
 	m, err = NewMember(Id)
 	if err != nil {
@@ -172,6 +419,6 @@ func (ms Members) GetById(Id string, AddWhenMissing bool) (m *Member, err error)
 	//So check if after leaving this method, that ms actually still holds the new values
 	ms[m.name] = m
 	ms[m.pair] = m
-	ms[m.dn] = m
+	ms[dnKey(m.dn)] = m
 	return m, nil
 }
@@ -0,0 +1,236 @@
+package ldap
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newTree returns the group named root from groups, which maps the names of groups to the names of their members.
+// Names that are not a key in groups are users.
+func newTree(t *testing.T, root string, groups map[string][]string) *Member {
+	members := make(Members)
+	get := func(name string) *Member {
+		mType, dn := UserMType, "uid="+name+",ou=users,dc=pgfga,dc=org"
+		if _, isGroup := groups[name]; isGroup {
+			mType, dn = GroupMType, "cn="+name+",ou=groups,dc=pgfga,dc=org"
+		}
+		m, err := members.GetNamed(dn, name, mType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	for groupName, memberNames := range groups {
+		group := get(groupName)
+		for _, memberName := range memberNames {
+			get(memberName).AddParent(group)
+		}
+	}
+	return get(root)
+}
+
+func TestMembershipTree(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		groups   map[string][]string
+		maxDepth int
+		expected []string
+	}{
+		{
+			name:     "cycle",
+			groups:   map[string][]string{"a": {"b"}, "b": {"a", "u"}},
+			expected: []string{"b:a", "u:b"},
+		},
+		{
+			name:     "self reference",
+			groups:   map[string][]string{"a": {"a", "u"}},
+			expected: []string{"u:a"},
+		},
+		{
+			name:     "memberships reached through several paths are returned once",
+			groups:   map[string][]string{"a": {"b", "c"}, "b": {"c", "u"}, "c": {"u"}},
+			expected: []string{"b:a", "c:a", "c:b", "u:b", "u:c"},
+		},
+		{
+			name:     "unlimited depth",
+			groups:   map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"u"}},
+			expected: []string{"b:a", "c:b", "u:c"},
+		},
+		{
+			name:     "maximum depth",
+			groups:   map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"u"}},
+			maxDepth: 2,
+			expected: []string{"b:a", "c:b"},
+		},
+		{
+			name:     "maximum depth of one",
+			groups:   map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"u"}},
+			maxDepth: 1,
+			expected: []string{"b:a"},
+		},
+		{
+			// c is first reached through b (too deep for the members of d), and then directly from a
+			name:     "group reached at a lower depth later is expanded again",
+			groups:   map[string][]string{"a": {"b", "c"}, "b": {"c"}, "c": {"d"}, "d": {"u"}},
+			maxDepth: 3,
+			expected: []string{"b:a", "c:a", "c:b", "d:c", "u:d"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var names []string
+			for _, ms := range newTree(t, "a", test.groups).MembershipTree(test.maxDepth) {
+				names = append(names, ms.Member.Name()+":"+ms.MemberOf.Name())
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestHandlerMembershipTreeDefaultDepth(t *testing.T) {
+	groups := make(map[string][]string)
+	for i := 0; i < 15; i++ {
+		groups[fmt.Sprintf("g%02d", i)] = []string{fmt.Sprintf("g%02d", i+1)}
+	}
+	groups["g15"] = []string{"u"}
+	// Without max_nesting_depth, groups are resolved at any depth
+	lh := NewLdapHandler(Config{})
+	if mss := lh.MembershipTree(newTree(t, "g00", groups)); len(mss) != 16 {
+		t.Errorf("expected all 16 memberships, got %d", len(mss))
+	}
+}
+
+func TestDnKey(t *testing.T) {
+	for _, test := range []struct {
+		dn       string
+		expected string
+	}{
+		{dn: "uid=jdoe,ou=users,dc=pgfga,dc=org", expected: "uid=jdoe,ou=users,dc=pgfga,dc=org"},
+		{dn: "UID=JDoe, OU=Users,DC=pgfga,DC=org", expected: "uid=jdoe,ou=users,dc=pgfga,dc=org"},
+		{dn: `cn=Doe\, John,ou=users,dc=pgfga,dc=org`, expected: `cn=doe\, john,ou=users,dc=pgfga,dc=org`},
+		{dn: `cn=Doe\2C John,ou=users,dc=pgfga,dc=org`, expected: `cn=doe\, john,ou=users,dc=pgfga,dc=org`},
+		// The attributes of a multi-valued RDN are sorted
+		{dn: "uid=jdoe+cn=John,ou=users", expected: "cn=john+uid=jdoe,ou=users"},
+		{dn: "CN=John+UID=jdoe,OU=users", expected: "cn=john+uid=jdoe,ou=users"},
+		{dn: `cn=\#1 \+ \"quoted\"\;\<\>\=,dc=org`, expected: `cn=\#1 \+ \"quoted\"\;\<\>\=,dc=org`},
+		// Invalid dns are only lowercased
+		{dn: "Not A DN", expected: "not a dn"},
+	} {
+		t.Run(test.dn, func(t *testing.T) {
+			if key := dnKey(test.dn); key != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, key)
+			}
+		})
+	}
+}
+
+func TestEscapeDnValue(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected string
+	}{
+		{value: "jdoe", expected: "jdoe"},
+		{value: "Doe, John", expected: `Doe\, John`},
+		{value: `a+b=c;d<e>f"g\h`, expected: `a\+b\=c\;d\<e\>f\"g\\h`},
+		{value: "#1", expected: `\#1`},
+		{value: "a#1", expected: "a#1"},
+		{value: " padded ", expected: `\ padded\ `},
+		{value: "nul\x00", expected: `nul\00`},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			escaped := escapeDnValue(test.value)
+			if escaped != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, escaped)
+			}
+			parsed, err := parseDn("cn=" + escaped)
+			if err != nil {
+				t.Fatalf("escaped value %s does not parse: %v", escaped, err)
+			}
+			if value := parsed.RDNs[0].Attributes[0].Value; value != test.value {
+				t.Errorf("expected %q after parsing, got %q", test.value, value)
+			}
+		})
+	}
+}
+
+func TestParseDn(t *testing.T) {
+	for _, test := range []struct {
+		dn    string
+		rdns  int
+		fails bool
+	}{
+		{dn: "uid=jdoe,ou=users,dc=pgfga,dc=org", rdns: 4},
+		{dn: `cn=Doe\, John+uid=jdoe,ou=users`, rdns: 2},
+		{dn: "", fails: true},
+		{dn: "jdoe", fails: true},
+		{dn: "=jdoe,ou=users", fails: true},
+	} {
+		t.Run(test.dn, func(t *testing.T) {
+			parsed, err := parseDn(test.dn)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(parsed.RDNs) != test.rdns {
+				t.Errorf("expected %d RDNs, got %d", test.rdns, len(parsed.RDNs))
+			}
+		})
+	}
+}
+
+func TestEqualDn(t *testing.T) {
+	for _, test := range []struct {
+		dn, other string
+		equal     bool
+	}{
+		{dn: "uid=jdoe,ou=users,dc=pgfga,dc=org", other: "UID=JDOE,OU=Users,DC=pgfga,DC=org", equal: true},
+		{dn: `cn=Doe\, John+uid=jdoe,ou=users`, other: `UID=jdoe+CN=doe\2c john,OU=users`, equal: true},
+		{dn: "uid=jdoe,ou=users", other: "uid=jdoe,ou=people", equal: false},
+		{dn: `cn=a\,b,ou=users`, other: "cn=a,b,ou=users", equal: false},
+	} {
+		if equal := equalDn(test.dn, test.other); equal != test.equal {
+			t.Errorf("expected equalDn(%s, %s) to be %t", test.dn, test.other, test.equal)
+		}
+	}
+}
+
+func TestCommonSuffix(t *testing.T) {
+	for _, test := range []struct {
+		dns      []string
+		expected string
+	}{
+		{dns: []string{"uid=jdoe,ou=users,dc=pgfga,dc=org"}, expected: "uid=jdoe,ou=users,dc=pgfga,dc=org"},
+		{dns: []string{"uid=jdoe,ou=users,dc=pgfga,dc=org", "UID=JSMITH,OU=Users,DC=pgfga,DC=org"},
+			expected: "ou=users,dc=pgfga,dc=org"},
+		{dns: []string{"uid=jdoe,ou=users,dc=pgfga,dc=org", "cn=dba,ou=groups,dc=pgfga,dc=org"},
+			expected: "dc=pgfga,dc=org"},
+		{dns: []string{"uid=jdoe,ou=users,dc=pgfga,dc=org", "cn=dba,o=example"}, expected: ""},
+		{dns: []string{"uid=jdoe,ou=users,dc=pgfga,dc=org", "not a dn"}, expected: ""},
+	} {
+		if suffix := commonSuffix(test.dns); suffix != test.expected {
+			t.Errorf("expected the common suffix of %v to be %q, got %q", test.dns, test.expected, suffix)
+		}
+	}
+}
+
+func TestSetFromIdMultiValuedRdn(t *testing.T) {
+	m, err := NewMember(`uid=jdoe+cn=Doe\, John,ou=users,dc=pgfga,dc=org`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Name() != "jdoe" || m.GetMType() != UserMType {
+		t.Errorf("expected user jdoe, got %s (type %d)", m.Name(), m.GetMType())
+	}
+	if m.Pair() != `uid=jdoe+cn=Doe\, John` {
+		t.Errorf("expected pair %s, got %s", `uid=jdoe+cn=Doe\, John`, m.Pair())
+	}
+}
@@ -0,0 +1,282 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.uber.org/zap"
+)
+
+func init() {
+	Initialize(zap.NewNop().Sugar())
+}
+
+// standIn is an in memory stand-in for a directory. Next to plain searches, it supports the Active Directory
+// specific behaviour that pgfga relies on: LDAP_MATCHING_RULE_IN_CHAIN in memberOf filters, and ranged retrieval of
+// member attributes (member;range=0-1499) for groups with more members than rangeSize.
+// Paged searches only accept cookies that were handed out by the same stand-in (the same connection), and the
+// connection can be lost after a number of pages with dropAfterPages.
+type standIn struct {
+	entries        []*ldap.Entry
+	rangeSize      int
+	searches       []*ldap.SearchRequest
+	closed         bool
+	cookies        map[string]bool
+	dropAfterPages int
+}
+
+// reconnect returns a new connection to the same directory
+func (si *standIn) reconnect() *standIn {
+	return &standIn{entries: si.entries, rangeSize: si.rangeSize}
+}
+
+// add adds an entry with attributes in the form name: value
+func (si *standIn) add(dn string, attributes map[string][]string) {
+	entry := &ldap.Entry{DN: dn}
+	for name, values := range attributes {
+		entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute(name, values))
+	}
+	si.entries = append(si.entries, entry)
+}
+
+func (si *standIn) entry(dn string) *ldap.Entry {
+	for _, entry := range si.entries {
+		if equalDn(entry.DN, dn) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// inSubtree returns true if dn is baseDN, or lives in the subtree of baseDN
+func inSubtree(dn string, baseDN string) bool {
+	key, baseKey := dnKey(dn), dnKey(baseDN)
+	return key == baseKey || strings.HasSuffix(key, ","+baseKey)
+}
+
+// memberOf returns true if the entry is a direct member of the group, or a nested member with inChain
+func (si *standIn) memberOf(dn string, groupDN string, inChain bool, visited map[string]bool) bool {
+	group := si.entry(groupDN)
+	if group == nil || visited[dnKey(groupDN)] {
+		return false
+	}
+	visited[dnKey(groupDN)] = true
+	for _, memberDN := range group.GetEqualFoldAttributeValues("member") {
+		if equalDn(memberDN, dn) || (inChain && si.memberOf(dn, memberDN, true, visited)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (si *standIn) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	si.searches = append(si.searches, searchRequest)
+	if si.closed {
+		return nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed"))
+	}
+	filter, rest, err := si.parseFilter(searchRequest.Filter)
+	if err != nil || rest != "" {
+		return nil, ldap.NewError(ldap.LDAPResultFilterError, fmt.Errorf("invalid filter %s", searchRequest.Filter))
+	}
+	result := &ldap.SearchResult{}
+	if searchRequest.Scope == ldap.ScopeBaseObject {
+		entry := si.entry(searchRequest.BaseDN)
+		if entry == nil {
+			return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))
+		}
+		if filter(entry) {
+			result.Entries = append(result.Entries, si.project(entry, searchRequest.Attributes))
+		}
+		return result, nil
+	}
+	for _, entry := range si.entries {
+		if inSubtree(entry.DN, searchRequest.BaseDN) && filter(entry) {
+			result.Entries = append(result.Entries, si.project(entry, searchRequest.Attributes))
+		}
+	}
+	return result, nil
+}
+
+func (si *standIn) SearchWithPaging(searchRequest *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult,
+	error) {
+	control, _ := ldap.FindControl(searchRequest.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	if control == nil {
+		control = ldap.NewControlPaging(pagingSize)
+		searchRequest.Controls = append(searchRequest.Controls, control)
+	} else if len(control.Cookie) > 0 && !si.cookies[string(control.Cookie)] {
+		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("invalid paging cookie"))
+	}
+	all, err := si.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if len(control.Cookie) > 0 {
+		start, _ = strconv.Atoi(string(control.Cookie))
+	}
+	result := &ldap.SearchResult{}
+	for page := 0; start < len(all.Entries); page++ {
+		if si.dropAfterPages > 0 && page == si.dropAfterPages {
+			si.closed = true
+			return result, ldap.NewError(ldap.ErrorNetwork, errors.New("connection lost"))
+		}
+		end := start + int(pagingSize)
+		if end > len(all.Entries) {
+			end = len(all.Entries)
+		}
+		result.Entries = append(result.Entries, all.Entries[start:end]...)
+		start = end
+		if start < len(all.Entries) {
+			// As go-ldap does, the cookie for the next page is set on the control of the request
+			if si.cookies == nil {
+				si.cookies = make(map[string]bool)
+			}
+			cookie := strconv.Itoa(start)
+			si.cookies[cookie] = true
+			control.SetCookie([]byte(cookie))
+		}
+	}
+	return result, nil
+}
+
+func (si *standIn) IsClosing() bool {
+	return si.closed
+}
+
+func (si *standIn) Close() {
+	si.closed = true
+}
+
+// project returns the entry with only the requested attributes, where member attributes with more values than
+// rangeSize are returned in ranges
+func (si *standIn) project(entry *ldap.Entry, attributes []string) *ldap.Entry {
+	projected := &ldap.Entry{DN: entry.DN}
+	for _, requested := range attributes {
+		name, start := requested, -1
+		if i := strings.Index(strings.ToLower(requested), rangeOption); i >= 0 {
+			name = requested[:i]
+			start, _ = strconv.Atoi(strings.TrimSuffix(requested[i+len(rangeOption):], "-*"))
+		}
+		values := entry.GetEqualFoldAttributeValues(name)
+		if len(values) == 0 {
+			continue
+		}
+		if si.rangeSize == 0 || !strings.EqualFold(name, "member") || (start < 0 && len(values) <= si.rangeSize) {
+			projected.Attributes = append(projected.Attributes, ldap.NewEntryAttribute(name, values))
+			continue
+		}
+		if start < 0 {
+			start = 0
+		}
+		end := start + si.rangeSize
+		rangeSpec := fmt.Sprintf("%d-%d", start, end-1)
+		if end >= len(values) {
+			end = len(values)
+			rangeSpec = fmt.Sprintf("%d-*", start)
+		}
+		projected.Attributes = append(projected.Attributes,
+			ldap.NewEntryAttribute(name+rangeOption+rangeSpec, values[start:end]))
+	}
+	return projected
+}
+
+// parseFilter parses the subset of ldap filters pgfga uses: and, or, not, presence, equality and the in chain
+// matching rule on memberOf
+func (si *standIn) parseFilter(filter string) (match func(*ldap.Entry) bool, rest string, err error) {
+	if !strings.HasPrefix(filter, "(") {
+		return nil, "", fmt.Errorf("expected ( in %s", filter)
+	}
+	filter = filter[1:]
+	switch filter[0] {
+	case '&', '|':
+		var matches []func(*ldap.Entry) bool
+		and := filter[0] == '&'
+		rest = filter[1:]
+		for strings.HasPrefix(rest, "(") {
+			var m func(*ldap.Entry) bool
+			m, rest, err = si.parseFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			matches = append(matches, m)
+		}
+		match = func(entry *ldap.Entry) bool {
+			for _, m := range matches {
+				if m(entry) != and {
+					return !and
+				}
+			}
+			return and
+		}
+	case '!':
+		var m func(*ldap.Entry) bool
+		m, rest, err = si.parseFilter(filter[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		match = func(entry *ldap.Entry) bool { return !m(entry) }
+	default:
+		end := strings.Index(filter, ")")
+		if end < 0 {
+			return nil, "", fmt.Errorf("expected ) in %s", filter)
+		}
+		match, err = si.parseItem(filter[:end])
+		if err != nil {
+			return nil, "", err
+		}
+		rest = filter[end:]
+	}
+	if !strings.HasPrefix(rest, ")") {
+		return nil, "", fmt.Errorf("expected ) in %s", rest)
+	}
+	return match, rest[1:], nil
+}
+
+func (si *standIn) parseItem(item string) (match func(*ldap.Entry) bool, err error) {
+	pair := strings.SplitN(item, "=", 2)
+	if len(pair) != 2 {
+		return nil, fmt.Errorf("invalid filter item %s", item)
+	}
+	attribute, value := pair[0], unescapeFilterValue(pair[1])
+	inChain := false
+	if strings.HasSuffix(attribute, ":"+matchingRuleInChain+":") {
+		attribute = strings.TrimSuffix(attribute, ":"+matchingRuleInChain+":")
+		inChain = true
+	}
+	switch {
+	case strings.EqualFold(attribute, "memberOf"):
+		return func(entry *ldap.Entry) bool {
+			return si.memberOf(entry.DN, value, inChain, make(map[string]bool))
+		}, nil
+	case inChain:
+		return nil, fmt.Errorf("in chain matching rule is only supported for memberOf")
+	case value == "*":
+		return func(entry *ldap.Entry) bool {
+			return strings.EqualFold(attribute, "objectClass") ||
+				len(entry.GetEqualFoldAttributeValues(attribute)) > 0
+		}, nil
+	default:
+		return func(entry *ldap.Entry) bool {
+			return containsFold(entry.GetEqualFoldAttributeValues(attribute), value)
+		}, nil
+	}
+}
+
+// unescapeFilterValue reverts ldap.EscapeFilter
+func unescapeFilterValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+2 < len(value) {
+			if c, err := strconv.ParseUint(value[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
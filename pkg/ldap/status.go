@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// adAccountDisable is the ACCOUNTDISABLE flag of the userAccountControl attribute in Active Directory
+	adAccountDisable = 0x2
+	// fileTimeEpochOffset is the number of seconds between 1601-01-01 (the epoch of Windows file times) and 1970-01-01
+	fileTimeEpochOffset = 11644473600
+	secondsPerDay       = 24 * 60 * 60
+)
+
+var generalizedTimeFormats = []string{"20060102150405Z0700", "20060102150405.999999999Z0700", "200601021504Z0700"}
+
+// statusDisabled returns true if the value of a status attribute means that the account is disabled
+func statusDisabled(attribute string, value string, now time.Time) (disabled bool, err error) {
+	switch strings.ToLower(attribute) {
+	case "useraccountcontrol":
+		flags, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid userAccountControl %s: %v", value, err)
+		}
+		return flags&adAccountDisable != 0, nil
+	case "pwdaccountlockedtime":
+		// The account is locked as long as the attribute exists
+		return true, nil
+	case "shadowexpire":
+		expiry, err := attributeExpiry(attribute, value)
+		if err != nil {
+			return false, err
+		}
+		return !expiry.IsZero() && !expiry.After(now), nil
+	default:
+		// e.a. nsAccountLock: true
+		switch strings.ToLower(value) {
+		case "true", "yes", "1":
+			return true, nil
+		}
+		return false, nil
+	}
+}
+
+// attributeExpiry returns the expiry from the value of an expiry attribute, or the zero time if it never expires
+func attributeExpiry(attribute string, value string) (expiry time.Time, err error) {
+	switch strings.ToLower(attribute) {
+	case "accountexpires":
+		// 100 nanosecond intervals since 1601-01-01, where 0 and the max value mean never
+		fileTime, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid accountExpires %s: %v", value, err)
+		}
+		if fileTime <= 0 || fileTime == math.MaxInt64 {
+			return time.Time{}, nil
+		}
+		return time.Unix(fileTime/1e7-fileTimeEpochOffset, (fileTime%1e7)*100).UTC(), nil
+	case "shadowexpire":
+		// days since 1970-01-01, where -1 means never
+		days, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid shadowExpire %s: %v", value, err)
+		}
+		if days < 0 {
+			return time.Time{}, nil
+		}
+		return time.Unix(days*secondsPerDay, 0).UTC(), nil
+	default:
+		// e.a. krbPrincipalExpiration or pwdEndTime, which are generalized times
+		for _, format := range generalizedTimeFormats {
+			expiry, err = time.Parse(format, value)
+			if err == nil {
+				return expiry.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid %s %s (expected a generalized time)", attribute, value)
+	}
+}
+
+// AccountStatus returns if the account of a user entry is disabled (from the status attributes), and when it
+// expires (the earliest expiry from the expiry attributes, or the zero time if it never expires)
+func (am AttributeMapping) AccountStatus(entry *ldap.Entry) (disabled bool, expiry time.Time, err error) {
+	now := time.Now()
+	for _, attribute := range am.StatusAttributes {
+		for _, value := range entry.GetEqualFoldAttributeValues(attribute) {
+			valueDisabled, err := statusDisabled(attribute, value, now)
+			if err != nil {
+				return false, time.Time{}, fmt.Errorf("%s: %v", entry.DN, err)
+			}
+			disabled = disabled || valueDisabled
+		}
+	}
+	for _, attribute := range am.ExpiryAttributes {
+		for _, value := range entry.GetEqualFoldAttributeValues(attribute) {
+			valueExpiry, err := attributeExpiry(attribute, value)
+			if err != nil {
+				return false, time.Time{}, fmt.Errorf("%s: %v", entry.DN, err)
+			}
+			if !valueExpiry.IsZero() && (expiry.IsZero() || valueExpiry.Before(expiry)) {
+				expiry = valueExpiry
+			}
+		}
+	}
+	return disabled, expiry, nil
+}
+
+// userAttributes returns the attributes that are read for user entries (and for group entries, for the comment)
+func (am AttributeMapping) userAttributes() (attributes []string) {
+	attributes = append([]string{am.NameAttribute}, am.StatusAttributes...)
+	attributes = append(attributes, am.ExpiryAttributes...)
+	return append(attributes, am.CommentAttributes...)
+}
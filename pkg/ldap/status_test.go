@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestStatusDisabled(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name      string
+		attribute string
+		value     string
+		disabled  bool
+		fails     bool
+	}{
+		{name: "enabled ad account", attribute: "userAccountControl", value: "512"},
+		{name: "disabled ad account", attribute: "userAccountControl", value: "514", disabled: true},
+		{name: "invalid userAccountControl", attribute: "userAccountControl", value: "normal", fails: true},
+		{name: "locked account", attribute: "pwdAccountLockedTime", value: "20240101000000Z", disabled: true},
+		{name: "expired shadow account", attribute: "shadowExpire", value: "19000", disabled: true},
+		{name: "shadow account expiring later", attribute: "shadowExpire", value: "20000"},
+		{name: "shadow account without expiry", attribute: "shadowExpire", value: "-1"},
+		{name: "nsAccountLock", attribute: "nsAccountLock", value: "TRUE", disabled: true},
+		{name: "nsAccountLock false", attribute: "nsAccountLock", value: "false"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			disabled, err := statusDisabled(test.attribute, test.value, now)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if disabled != test.disabled {
+				t.Errorf("expected disabled to be %t", test.disabled)
+			}
+		})
+	}
+}
+
+func TestAttributeExpiry(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		attribute string
+		value     string
+		expected  time.Time
+		fails     bool
+	}{
+		{name: "accountExpires", attribute: "accountExpires", value: "133485408000000000",
+			expected: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "accountExpires never", attribute: "accountExpires", value: "9223372036854775807"},
+		{name: "accountExpires zero", attribute: "accountExpires", value: "0"},
+		{name: "invalid accountExpires", attribute: "accountExpires", value: "never", fails: true},
+		{name: "shadowExpire", attribute: "shadowExpire", value: "19723",
+			expected: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "shadowExpire never", attribute: "shadowExpire", value: "-1"},
+		{name: "generalized time", attribute: "krbPrincipalExpiration", value: "20240101120000Z",
+			expected: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "generalized time with offset", attribute: "pwdEndTime", value: "20240101120000+0200",
+			expected: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{name: "generalized time with fraction", attribute: "pwdEndTime", value: "20240101120000.5Z",
+			expected: time.Date(2024, 1, 1, 12, 0, 0, 500000000, time.UTC)},
+		{name: "generalized time without seconds", attribute: "pwdEndTime", value: "202401011200Z",
+			expected: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "invalid generalized time", attribute: "pwdEndTime", value: "2024-01-01", fails: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			expiry, err := attributeExpiry(test.attribute, test.value)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !expiry.Equal(test.expected) {
+				t.Errorf("expected %s, got %s", test.expected, expiry)
+			}
+		})
+	}
+}
+
+func TestAccountStatus(t *testing.T) {
+	mapping := AttributeMapping{
+		StatusAttributes: []string{"userAccountControl", "nsAccountLock"},
+		ExpiryAttributes: []string{"accountExpires", "pwdEndTime"},
+	}
+	entry := ldap.NewEntry("CN=Jane,OU=Users,DC=example,DC=com", map[string][]string{
+		"userAccountControl": {"512"},
+		"nsAccountLock":      {"true"},
+		// The earliest expiry wins
+		"accountExpires": {"133485408000000000"},
+		"pwdEndTime":     {"20230101000000Z"},
+	})
+	disabled, expiry, err := mapping.AccountStatus(entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !disabled {
+		t.Errorf("expected the account to be disabled by nsAccountLock")
+	}
+	if expected := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC); !expiry.Equal(expected) {
+		t.Errorf("expected expiry %s, got %s", expected, expiry)
+	}
+
+	disabled, expiry, err = AttributeMapping{}.AccountStatus(entry)
+	if err != nil || disabled || !expiry.IsZero() {
+		t.Errorf("expected no status without status and expiry attributes, got %t, %s, %v", disabled, expiry, err)
+	}
+}
@@ -0,0 +1,95 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig defines how connections to the ldap servers are encrypted. ldaps:// servers always use TLS, ldap://
+// servers only when StartTLS is set.
+type TLSConfig struct {
+	StartTLS bool   `yaml:"start_tls"`
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// SaslExternal binds with SASL EXTERNAL (the identity of the client certificate) instead of user and password
+	SaslExternal bool   `yaml:"sasl_external"`
+	ServerName   string `yaml:"server_name"`
+	MinVersion   string `yaml:"min_version"`
+}
+
+func (tc TLSConfig) validate() (err error) {
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file should be set together")
+	}
+	if tc.SaslExternal && tc.CertFile == "" {
+		return fmt.Errorf("tls sasl_external requires a client certificate (cert_file and key_file)")
+	}
+	if _, exists := tlsVersions[tc.MinVersion]; tc.MinVersion != "" && !exists {
+		return fmt.Errorf("invalid tls min_version %s (should be one of 1.0, 1.1, 1.2 or 1.3)", tc.MinVersion)
+	}
+	return nil
+}
+
+// usesTLS returns true if the connection to the server will be encrypted
+func (tc TLSConfig) usesTLS(server string) bool {
+	return tc.StartTLS || strings.HasPrefix(strings.ToLower(server), "ldaps://")
+}
+
+// tlsConfig returns the tls configuration for connecting to a server
+func (tc TLSConfig) tlsConfig(server string) (config *tls.Config, err error) {
+	err = tc.validate()
+	if err != nil {
+		return nil, err
+	}
+	config = &tls.Config{
+		ServerName: tc.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if tc.MinVersion != "" {
+		config.MinVersion = tlsVersions[tc.MinVersion]
+	}
+	if config.ServerName == "" {
+		// StartTLS requires the server name to verify the certificate of the server
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = u.Host
+		if host, _, err := net.SplitHostPort(u.Host); err == nil {
+			config.ServerName = host
+		}
+	}
+	if tc.CAFile != "" {
+		// Reading the CA bundle from a configurable location is the whole point
+		// #nosec
+		pem, err := os.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls ca_file %s", tc.CAFile)
+		}
+	}
+	if tc.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,53 @@
+package pg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ByteSize represents an amount of bytes, which can be set in yaml as a number or as a string with a unit (e.a. 10GB)
+type ByteSize int64
+
+var (
+	// units are B, kB, MB, GB, TB, KiB, MiB, GiB and TiB, matched case-insensitively
+	byteSizeRe    = regexp.MustCompile(`^(\d+)\s*(b|[kmgt]i?b)?$`)
+	byteSizeUnits = map[string]int64{
+		"":  1,
+		"b": 1,
+		"k": 1 << 10,
+		"m": 1 << 20,
+		"g": 1 << 30,
+		"t": 1 << 40,
+	}
+)
+
+// UnmarshalYAML converts a yaml number or string to a ByteSize
+func (bs *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	match := byteSizeRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(str)))
+	if match == nil {
+		return fmt.Errorf("invalid byte size %s (should be a number with an optional unit like kB, MB, GB, TB, KiB, MiB, GiB or TiB)", str)
+	}
+	value, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	unit := strings.TrimSuffix(strings.TrimSuffix(match[2], "b"), "i")
+	*bs = ByteSize(value * byteSizeUnits[unit])
+	return nil
+}
+
+func (bs ByteSize) String() string {
+	for _, unit := range []string{"T", "G", "M", "k"} {
+		factor := byteSizeUnits[strings.ToLower(unit)]
+		if int64(bs) >= factor && int64(bs)%factor == 0 {
+			return fmt.Sprintf("%d%sB", int64(bs)/factor, unit)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(bs))
+}
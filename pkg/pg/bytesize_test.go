@@ -0,0 +1,68 @@
+package pg
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestByteSizeUnmarshalYAML(t *testing.T) {
+	for _, test := range []struct {
+		yaml     string
+		expected ByteSize
+		fails    bool
+	}{
+		{yaml: "1024", expected: 1024},
+		{yaml: "100B", expected: 100},
+		{yaml: "10kB", expected: 10 << 10},
+		{yaml: "10 KiB", expected: 10 << 10},
+		{yaml: "512MB", expected: 512 << 20},
+		{yaml: "10GB", expected: 10 << 30},
+		{yaml: "1gib", expected: 1 << 30},
+		{yaml: "2TB", expected: 2 << 40},
+		{yaml: "10PB", fails: true},
+		{yaml: "-1GB", fails: true},
+		{yaml: "GB", fails: true},
+		{yaml: "1.5GB", fails: true},
+		{yaml: "1i", fails: true},
+		{yaml: "1ib", fails: true},
+		{yaml: "1k", fails: true},
+		{yaml: "1Gi", fails: true},
+		{yaml: "1bb", fails: true},
+	} {
+		t.Run(test.yaml, func(t *testing.T) {
+			var bs ByteSize
+			err := yaml.Unmarshal([]byte(test.yaml), &bs)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error, got %d", bs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bs != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, bs)
+			}
+		})
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	for _, test := range []struct {
+		bs       ByteSize
+		expected string
+	}{
+		{bs: 0, expected: "0B"},
+		{bs: 1000, expected: "1000B"},
+		{bs: 1 << 10, expected: "1kB"},
+		{bs: 1536 << 10, expected: "1536kB"},
+		{bs: 10 << 30, expected: "10GB"},
+		{bs: 2 << 40, expected: "2TB"},
+	} {
+		if s := test.bs.String(); s != test.expected {
+			t.Errorf("expected %d to render as %s, got %s", test.bs, test.expected, s)
+		}
+	}
+}
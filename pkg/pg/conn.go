@@ -6,6 +6,7 @@ import (
 	"github.com/jackc/pgx/v4"
 	"os"
 	"os/user"
+	"strconv"
 	"strings"
 )
 
@@ -109,3 +110,34 @@ func (c *Conn) runQueryGetOneField(query string, args ...interface{}) (answer st
 	}
 	return answer, nil
 }
+
+func (c *Conn) runQueryGetOneColumn(query string, args ...interface{}) (answers []string, err error) {
+	err = c.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.conn.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("runQueryGetOneColumn (%s) failed: %v", query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var answer string
+		err = rows.Scan(&answer)
+		if err != nil {
+			return nil, fmt.Errorf("runQueryGetOneColumn (%s) failed: %v", query, err)
+		}
+		answers = append(answers, answer)
+	}
+	return answers, rows.Err()
+}
+
+// ServerVersionNum returns the server version as an integer (e.a. 140005 for 14.5)
+func (c *Conn) ServerVersionNum() (version int, err error) {
+	answer, err := c.runQueryGetOneField("SELECT current_setting('server_version_num')")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(answer)
+}
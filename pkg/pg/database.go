@@ -13,16 +13,20 @@ type Database struct {
 	handler *Handler
 	name    string
 	// conn is created from handler when required
-	conn       *Conn
-	Owner      string     `yaml:"owner"`
-	Extensions Extensions `yaml:"extensions"`
-	State      State      `yaml:"state"`
+	conn           *Conn
+	Owner          string         `yaml:"owner"`
+	Tablespace     string         `yaml:"tablespace"`
+	Extensions     Extensions     `yaml:"extensions"`
+	Publications   Publications   `yaml:"publications"`
+	Subscriptions  Subscriptions  `yaml:"subscriptions"`
+	ForeignServers ForeignServers `yaml:"foreign_servers"`
+	State          State          `yaml:"state"`
 }
 
 func NewDatabase(handler *Handler, name string, owner string) (d *Database) {
 	db, exists := handler.databases[name]
 	if exists {
-		if db.Owner != owner {
+		if owner != "" && db.Owner != owner {
 			log.Debugf("Warning: DB %s already exists with different owner %s. CHanging to owner %s.", name,
 				db.Owner, owner)
 			db.Owner = owner
@@ -30,17 +34,20 @@ func NewDatabase(handler *Handler, name string, owner string) (d *Database) {
 		return db
 	}
 	d = &Database{
-		handler:    handler,
-		name:       name,
-		Owner:      owner,
-		Extensions: make(Extensions),
+		handler:        handler,
+		name:           name,
+		Owner:          owner,
+		Extensions:     make(Extensions),
+		Publications:   make(Publications),
+		Subscriptions:  make(Subscriptions),
+		ForeignServers: make(ForeignServers),
 	}
 	d.SetDefaults()
 	handler.databases[name] = d
 	return d
 }
 
-//SetDefaults is called to set all defaults for databases created from yaml
+// SetDefaults is called to set all defaults for databases created from yaml
 func (d *Database) SetDefaults() {
 	if d.Owner == "" {
 		d.Owner = d.name
@@ -49,6 +56,20 @@ func (d *Database) SetDefaults() {
 		ext.db = d
 		ext.name = name
 	}
+	for name, pub := range d.Publications {
+		pub.db = d
+		pub.name = name
+	}
+	for name, sub := range d.Subscriptions {
+		sub.db = d
+		sub.name = name
+		sub.SetDefaults()
+	}
+	for name, fs := range d.ForeignServers {
+		fs.db = d
+		fs.name = name
+		fs.SetDefaults()
+	}
 }
 
 func (d *Database) GetDbConnection() (c *Conn) {
@@ -72,7 +93,7 @@ func (d *Database) GetDbConnection() (c *Conn) {
 
 func (d *Database) Drop() (err error) {
 	ph := d.handler
-	if ! ph.strictOptions.Databases {
+	if !ph.strictOptions.Databases {
 		log.Infof("skipping drop of database %s (not running with strict option for databases", d.name)
 		return nil
 	}
@@ -81,16 +102,34 @@ func (d *Database) Drop() (err error) {
 		return err
 	}
 	if exists {
-		err = ph.conn.runQueryExec(fmt.Sprintf("drop database %s", identifier(d.name)))
+		err = ph.remove(databaseDeletions, d.name, func() (err error) {
+			err = ph.conn.runQueryExec(fmt.Sprintf("drop database %s", identifier(d.name)))
+			if err != nil {
+				return err
+			}
+			log.Infof("Database '%s' succesfully dropped", d.name)
+			return nil
+		})
 		if err != nil {
 			return err
 		}
-		log.Infof("Database '%s' succesfully dropped", d.name)
 	}
 	d.State = Absent
 	return nil
 }
 
+// checkTablespace returns an error when the database references a tablespace that is configured to be absent
+func (d Database) checkTablespace() (err error) {
+	if d.Tablespace == "" {
+		return nil
+	}
+	if ts, managed := d.handler.tablespaces[d.Tablespace]; managed && !ts.State.Bool() {
+		return fmt.Errorf("database %s references tablespace %s, which is configured to be absent", d.name,
+			d.Tablespace)
+	}
+	return nil
+}
+
 func (d Database) Create() (err error) {
 	ph := d.handler
 
@@ -98,12 +137,31 @@ func (d Database) Create() (err error) {
 	if err != nil {
 		return err
 	}
+	err = d.checkTablespace()
+	if err != nil {
+		return err
+	}
 	if !exists {
-		err = ph.conn.runQueryExec(fmt.Sprintf("CREATE DATABASE %s", identifier(d.name)))
+		createQry := fmt.Sprintf("CREATE DATABASE %s", identifier(d.name))
+		if d.Tablespace != "" {
+			createQry += " TABLESPACE " + identifier(d.Tablespace)
+		}
+		err = ph.conn.runQueryExec(createQry)
 		if err != nil {
 			return err
 		}
 		log.Infof("Database '%s' succesfully created", d.name)
+	} else if d.Tablespace != "" {
+		exists, err = ph.conn.runQueryExists(`SELECT datname FROM pg_database db INNER JOIN pg_tablespace spc
+			ON db.dattablespace = spc.oid WHERE datname = $1 AND spcname = $2`, d.name, d.Tablespace)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// Moving a database copies all of its data under an exclusive lock, which is left to the DBA
+			log.Warnf("Database '%s' exists, but in another tablespace than '%s' (move it with ALTER DATABASE ... "+
+				"SET TABLESPACE)", d.name, d.Tablespace)
+		}
 	}
 	exists, err = ph.conn.runQueryExists("SELECT datname FROM pg_database db inner join pg_roles rol on db.datdba = rol.oid WHERE datname = $1 and rolname = $2", d.name, d.Owner)
 	if err != nil {
@@ -126,6 +184,18 @@ func (d Database) Create() (err error) {
 	if err != nil {
 		return err
 	}
+	err = d.CreateOrDropPublications()
+	if err != nil {
+		return err
+	}
+	err = d.CreateOrDropSubscriptions()
+	if err != nil {
+		return err
+	}
+	err = d.CreateOrDropForeignServers()
+	if err != nil {
+		return err
+	}
 	err = ph.GrantRole(d.Owner, "opex")
 	if err != nil {
 		return err
@@ -194,3 +264,45 @@ func (d *Database) CreateOrDropExtensions() (err error) {
 	}
 	return nil
 }
+
+func (d *Database) CreateOrDropPublications() (err error) {
+	for _, p := range d.Publications {
+		if p.State.Bool() {
+			err = p.Create()
+		} else {
+			err = p.Drop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Database) CreateOrDropSubscriptions() (err error) {
+	for _, s := range d.Subscriptions {
+		if s.State.Bool() {
+			err = s.Create()
+		} else {
+			err = s.Drop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Database) CreateOrDropForeignServers() (err error) {
+	for _, fs := range d.ForeignServers {
+		if fs.State.Bool() {
+			err = fs.Create()
+		} else {
+			err = fs.Drop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
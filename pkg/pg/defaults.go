@@ -1,5 +1,7 @@
 package pg
 
+import "strings"
+
 var (
 	ProtectedRoles = map[string]bool{"aq_administrator_role": true,
 		"enterprisedb":              true,
@@ -19,3 +21,9 @@ var (
 		"template1": true,
 	}
 )
+
+// IsProtectedRole returns true for roles that pgfga should never create, revoke or drop from ldap, which are the
+// ProtectedRoles and all predefined roles (pg_*)
+func IsProtectedRole(name string) bool {
+	return ProtectedRoles[name] || strings.HasPrefix(name, "pg_")
+}
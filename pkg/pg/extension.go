@@ -52,12 +52,18 @@ func (e *Extension) Drop() (err error) {
 	}
 
 	dbConn := ph.GetDb(e.db.name).GetDbConnection()
-	err = dbConn.runQueryExec("DROP EXTENSION IF EXISTS " + identifier(e.name))
+	err = ph.remove(extensionDeletions, e.db.name+"."+e.name, func() (err error) {
+		err = dbConn.runQueryExec("DROP EXTENSION IF EXISTS " + identifier(e.name))
+		if err != nil {
+			return err
+		}
+		log.Infof("Extension '%s'.'%s' succesfully dropped.", e.db.name, e.name)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 	e.State = Absent
-	log.Infof("Extension '%s'.'%s' succesfully dropped.", e.db.name, e.name)
 	return nil
 }
 
@@ -0,0 +1,349 @@
+package pg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pgvillage-tools/pgfga/pkg/credential"
+)
+
+// publicMapping is the role name used for user mappings that apply to all roles
+const publicMapping = "public"
+
+type ForeignServers map[string]*ForeignServer
+
+type ForeignServer struct {
+	// name and db are set by the database
+	db            *Database
+	name          string
+	Wrapper       string            `yaml:"wrapper"`
+	Options       map[string]string `yaml:"options"`
+	UserMappings  UserMappings      `yaml:"user_mappings"`
+	ImportSchemas []ForeignSchema   `yaml:"import_schemas"`
+	State         State             `yaml:"state"`
+}
+
+type UserMappings map[string]*UserMapping
+
+type UserMapping struct {
+	// server and role are set by the foreign server
+	server     *ForeignServer
+	role       string
+	RemoteUser string                `yaml:"remote_user"`
+	Password   credential.Credential `yaml:"password"`
+	Options    map[string]string     `yaml:"options"`
+	State      State                 `yaml:"state"`
+}
+
+// ForeignSchema defines a remote schema to be imported with IMPORT FOREIGN SCHEMA
+type ForeignSchema struct {
+	RemoteSchema string   `yaml:"remote_schema"`
+	LocalSchema  string   `yaml:"local_schema"`
+	LimitTo      []string `yaml:"limit_to"`
+}
+
+// SetDefaults is called to set all defaults for foreign servers created from yaml
+func (fs *ForeignServer) SetDefaults() {
+	if fs.Wrapper == "" {
+		fs.Wrapper = "postgres_fdw"
+	}
+	for role, um := range fs.UserMappings {
+		if um == nil {
+			um = &UserMapping{}
+			fs.UserMappings[role] = um
+		}
+		um.server = fs
+		um.role = role
+	}
+	for i, schema := range fs.ImportSchemas {
+		if schema.LocalSchema == "" {
+			fs.ImportSchemas[i].LocalSchema = schema.RemoteSchema
+		}
+	}
+}
+
+// optionsList returns the options as used in the OPTIONS clause of CREATE SERVER and CREATE USER MAPPING
+func optionsList(wanted map[string]string) (options []string) {
+	for name, value := range wanted {
+		options = append(options, fmt.Sprintf("%s %s", identifier(name), quotedSqlValue(value)))
+	}
+	sort.Strings(options)
+	return options
+}
+
+// optionsDiff returns the actions (as used in the OPTIONS clause of ALTER SERVER and ALTER USER MAPPING) to change
+// the current options into the wanted options
+func optionsDiff(wanted map[string]string, current []string) (actions []string) {
+	currentOptions := make(map[string]string)
+	for _, option := range current {
+		pair := strings.SplitN(option, "=", 2)
+		if len(pair) == 2 {
+			currentOptions[pair[0]] = pair[1]
+		}
+	}
+	for name, value := range wanted {
+		currentValue, exists := currentOptions[name]
+		if !exists {
+			actions = append(actions, fmt.Sprintf("ADD %s %s", identifier(name), quotedSqlValue(value)))
+		} else if currentValue != value {
+			actions = append(actions, fmt.Sprintf("SET %s %s", identifier(name), quotedSqlValue(value)))
+		}
+	}
+	for name := range currentOptions {
+		if _, exists := wanted[name]; !exists {
+			actions = append(actions, fmt.Sprintf("DROP %s", identifier(name)))
+		}
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+func (fs *ForeignServer) Drop() (err error) {
+	if !fs.db.handler.strictOptions.ForeignServers {
+		log.Infof("not dropping foreign server '%s'.'%s' (config.strict.foreign_servers is not True)", fs.db.name,
+			fs.name)
+		return nil
+	}
+	c := fs.db.GetDbConnection()
+	exists, err := c.runQueryExists("SELECT srvname FROM pg_foreign_server WHERE srvname = $1", fs.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	err = fs.db.handler.remove(foreignServerDeletions, fs.db.name+"."+fs.name, func() (err error) {
+		err = c.runQueryExec("DROP SERVER " + identifier(fs.name) + " CASCADE")
+		if err != nil {
+			return err
+		}
+		log.Infof("Foreign server '%s'.'%s' succesfully dropped.", fs.db.name, fs.name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fs.State = Absent
+	return nil
+}
+
+func (fs ForeignServer) Create() (err error) {
+	c := fs.db.GetDbConnection()
+	exists, err := c.runQueryExists("SELECT fdwname FROM pg_foreign_data_wrapper WHERE fdwname = $1", fs.Wrapper)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("foreign data wrapper %s for server %s does not exist in db %s (add it as an extension)",
+			fs.Wrapper, fs.name, fs.db.name)
+	}
+	exists, err = c.runQueryExists("SELECT srvname FROM pg_foreign_server WHERE srvname = $1", fs.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		createQry := fmt.Sprintf("CREATE SERVER %s FOREIGN DATA WRAPPER %s", identifier(fs.name),
+			identifier(fs.Wrapper))
+		if options := optionsList(fs.Options); len(options) > 0 {
+			createQry += fmt.Sprintf(" OPTIONS (%s)", strings.Join(options, ", "))
+		}
+		err = c.runQueryExec(createQry)
+		if err != nil {
+			return err
+		}
+		log.Infof("Foreign server '%s'.'%s' succesfully created.", fs.db.name, fs.name)
+	} else {
+		exists, err = c.runQueryExists(`SELECT srvname FROM pg_foreign_server srv INNER JOIN pg_foreign_data_wrapper fdw
+			ON srv.srvfdw = fdw.oid WHERE srvname = $1 AND fdwname = $2`, fs.name, fs.Wrapper)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Warnf("Foreign server '%s'.'%s' exists, but with another wrapper than '%s'", fs.db.name, fs.name,
+				fs.Wrapper)
+		}
+		current, err := c.runQueryGetOneColumn("SELECT unnest(srvoptions) FROM pg_foreign_server WHERE srvname = $1",
+			fs.name)
+		if err != nil {
+			return err
+		}
+		if actions := optionsDiff(fs.Options, current); len(actions) > 0 {
+			err = c.runQueryExec(fmt.Sprintf("ALTER SERVER %s OPTIONS (%s)", identifier(fs.name),
+				strings.Join(actions, ", ")))
+			if err != nil {
+				return err
+			}
+			log.Infof("Foreign server '%s'.'%s' succesfully altered with new options", fs.db.name, fs.name)
+		}
+	}
+	for _, um := range fs.UserMappings {
+		if um.State.Bool() {
+			err = um.Create()
+		} else {
+			err = um.Drop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, schema := range fs.ImportSchemas {
+		err = fs.importSchema(schema)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importSchema imports all remote tables which are not yet imported as foreign tables in the local schema
+func (fs ForeignServer) importSchema(schema ForeignSchema) (err error) {
+	if schema.RemoteSchema == "" {
+		return fmt.Errorf("remote_schema must be set for all import_schemas of foreign server %s", fs.name)
+	}
+	c := fs.db.GetDbConnection()
+	err = c.runQueryExec("CREATE SCHEMA IF NOT EXISTS " + identifier(schema.LocalSchema))
+	if err != nil {
+		return err
+	}
+	qry := `SELECT cl.relname FROM pg_foreign_table ft
+		INNER JOIN pg_class cl ON ft.ftrelid = cl.oid
+		INNER JOIN pg_namespace n ON cl.relnamespace = n.oid
+		INNER JOIN pg_foreign_server srv ON ft.ftserver = srv.oid
+		WHERE srv.srvname = $1 AND n.nspname = $2`
+	imported, err := c.runQueryGetOneColumn(qry, fs.name, schema.LocalSchema)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	var tableFilter string
+	if len(schema.LimitTo) > 0 {
+		missing, _ := listDiff(schema.LimitTo, imported)
+		if len(missing) == 0 {
+			return nil
+		}
+		for _, table := range missing {
+			tables = append(tables, identifier(table))
+		}
+		tableFilter = fmt.Sprintf(" LIMIT TO (%s)", strings.Join(tables, ", "))
+	} else if len(imported) > 0 {
+		for _, table := range imported {
+			tables = append(tables, identifier(table))
+		}
+		tableFilter = fmt.Sprintf(" EXCEPT (%s)", strings.Join(tables, ", "))
+	}
+	err = c.runQueryExec(fmt.Sprintf("IMPORT FOREIGN SCHEMA %s%s FROM SERVER %s INTO %s",
+		identifier(schema.RemoteSchema), tableFilter, identifier(fs.name), identifier(schema.LocalSchema)))
+	if err != nil {
+		return err
+	}
+	log.Infof("Foreign schema '%s' from server '%s' succesfully imported into '%s'.'%s'", schema.RemoteSchema,
+		fs.name, fs.db.name, schema.LocalSchema)
+	return nil
+}
+
+func (um UserMapping) roleSql() (role string) {
+	if um.role == publicMapping {
+		return "PUBLIC"
+	}
+	return identifier(um.role)
+}
+
+func (um UserMapping) options() (options map[string]string, err error) {
+	options = make(map[string]string)
+	for name, value := range um.Options {
+		options[name] = value
+	}
+	if um.RemoteUser != "" {
+		options["user"] = um.RemoteUser
+	}
+	if um.Password.Value != "" || um.Password.File != "" {
+		password, err := um.Password.GetCred()
+		if err != nil {
+			return nil, fmt.Errorf("could not get password for user mapping %s on server %s: %v", um.role,
+				um.server.name, err)
+		}
+		options["password"] = strings.TrimSpace(password)
+	}
+	return options, nil
+}
+
+func (um *UserMapping) Drop() (err error) {
+	fs := um.server
+	if !fs.db.handler.strictOptions.ForeignServers {
+		log.Infof("not dropping user mapping for '%s' on server '%s'.'%s' (config.strict.foreign_servers is not True)",
+			um.role, fs.db.name, fs.name)
+		return nil
+	}
+	c := fs.db.GetDbConnection()
+	exists, err := c.runQueryExists("SELECT usename FROM pg_user_mappings WHERE srvname = $1 AND usename = $2",
+		fs.name, um.role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	err = fs.db.handler.remove(userMappingDeletions, fmt.Sprintf("%s on %s.%s", um.role, fs.db.name, fs.name),
+		func() (err error) {
+			err = c.runQueryExec(fmt.Sprintf("DROP USER MAPPING FOR %s SERVER %s", um.roleSql(), identifier(fs.name)))
+			if err != nil {
+				return err
+			}
+			log.Infof("User mapping for '%s' on server '%s'.'%s' succesfully dropped.", um.role, fs.db.name, fs.name)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	um.State = Absent
+	return nil
+}
+
+func (um UserMapping) Create() (err error) {
+	fs := um.server
+	c := fs.db.GetDbConnection()
+	if um.role != publicMapping {
+		// First make sure role exists
+		_, err = fs.db.handler.GetRole(um.role)
+		if err != nil {
+			return err
+		}
+	}
+	options, err := um.options()
+	if err != nil {
+		return err
+	}
+	exists, err := c.runQueryExists("SELECT usename FROM pg_user_mappings WHERE srvname = $1 AND usename = $2",
+		fs.name, um.role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		createQry := fmt.Sprintf("CREATE USER MAPPING FOR %s SERVER %s", um.roleSql(), identifier(fs.name))
+		if options := optionsList(options); len(options) > 0 {
+			createQry += fmt.Sprintf(" OPTIONS (%s)", strings.Join(options, ", "))
+		}
+		err = c.runQueryExec(createQry)
+		if err != nil {
+			return err
+		}
+		log.Infof("User mapping for '%s' on server '%s'.'%s' succesfully created.", um.role, fs.db.name, fs.name)
+		return nil
+	}
+	current, err := c.runQueryGetOneColumn(`SELECT unnest(umoptions) FROM pg_user_mappings
+		WHERE srvname = $1 AND usename = $2`, fs.name, um.role)
+	if err != nil {
+		return err
+	}
+	if actions := optionsDiff(options, current); len(actions) > 0 {
+		err = c.runQueryExec(fmt.Sprintf("ALTER USER MAPPING FOR %s SERVER %s OPTIONS (%s)", um.roleSql(),
+			identifier(fs.name), strings.Join(actions, ", ")))
+		if err != nil {
+			return err
+		}
+		log.Infof("User mapping for '%s' on server '%s'.'%s' succesfully altered with new options", um.role,
+			fs.db.name, fs.name)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptionsList(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		wanted   map[string]string
+		expected []string
+	}{
+		{name: "no options", wanted: nil, expected: nil},
+		{
+			name:     "options",
+			wanted:   map[string]string{"port": "5432", "host": "db1"},
+			expected: []string{`"host" 'db1'`, `"port" '5432'`},
+		},
+		{
+			name:     "quoting",
+			wanted:   map[string]string{"password": "it's"},
+			expected: []string{`"password" 'it''s'`},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if options := optionsList(test.wanted); !reflect.DeepEqual(options, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, options)
+			}
+		})
+	}
+}
+
+func TestOptionsDiff(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		wanted   map[string]string
+		current  []string
+		expected []string
+	}{
+		{name: "unchanged", wanted: map[string]string{"host": "db1"}, current: []string{"host=db1"}},
+		{
+			name:     "add",
+			wanted:   map[string]string{"host": "db1", "port": "5432"},
+			current:  []string{"host=db1"},
+			expected: []string{`ADD "port" '5432'`},
+		},
+		{
+			name:     "set",
+			wanted:   map[string]string{"host": "db2"},
+			current:  []string{"host=db1"},
+			expected: []string{`SET "host" 'db2'`},
+		},
+		{
+			name:     "drop",
+			wanted:   map[string]string{},
+			current:  []string{"host=db1"},
+			expected: []string{`DROP "host"`},
+		},
+		{
+			name:     "value with equal sign",
+			wanted:   map[string]string{"options": "-c a=b"},
+			current:  []string{"options=-c a=b"},
+			expected: nil,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if actions := optionsDiff(test.wanted, test.current); !reflect.DeepEqual(actions, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actions)
+			}
+		})
+	}
+}
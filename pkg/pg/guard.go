@@ -0,0 +1,146 @@
+package pg
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	roleDeletions       = "roles"
+	membershipDeletions = "memberships"
+	databaseDeletions   = "databases"
+	slotDeletions       = "replication slots"
+	// loginDeletions are users that are locked out (NOLOGIN) when they departed from all ldap groups
+	loginDeletions         = "logins"
+	tablespaceDeletions    = "tablespaces"
+	subscriptionDeletions  = "subscriptions"
+	extensionDeletions     = "extensions"
+	publicationDeletions   = "publications"
+	foreignServerDeletions = "foreign servers"
+	userMappingDeletions   = "user mappings"
+)
+
+var (
+	deletionKinds = []string{roleDeletions, membershipDeletions, databaseDeletions, slotDeletions, loginDeletions,
+		tablespaceDeletions, subscriptionDeletions, extensionDeletions, publicationDeletions, foreignServerDeletions,
+		userMappingDeletions}
+	// deletionTotalQueries count the existing objects, which is the base for MaxPercentage. Extensions, publications,
+	// foreign servers and user mappings live in a database, so there is no total for them, and only MaxDeletions
+	// applies.
+	deletionTotalQueries = map[string]string{
+		roleDeletions:       "SELECT count(*) FROM pg_roles WHERE rolname !~ '^pg_'",
+		membershipDeletions: "SELECT count(*) FROM pg_auth_members",
+		databaseDeletions:   "SELECT count(*) FROM pg_database WHERE NOT datistemplate",
+		slotDeletions:       "SELECT count(*) FROM pg_replication_slots",
+		loginDeletions:      "SELECT count(*) FROM pg_roles WHERE rolcanlogin",
+		tablespaceDeletions: "SELECT count(*) FROM pg_tablespace WHERE spcname !~ '^pg_'",
+		// pg_subscription is a shared catalog
+		subscriptionDeletions: "SELECT count(*) FROM pg_subscription",
+	}
+)
+
+// MassDeletionGuard defines how many roles, memberships, databases, replication slots and logins one run may remove
+// (per kind of object). When enabled, removals are postponed until all other changes are applied, and are only applied
+// when the thresholds are not exceeded (or when that is explicitly allowed).
+type MassDeletionGuard struct {
+	MaxDeletions  int     `yaml:"max_deletions"`
+	MaxPercentage float64 `yaml:"max_percentage"`
+}
+
+// Enabled returns true if any of the thresholds is set
+func (g MassDeletionGuard) Enabled() bool {
+	return g.MaxDeletions > 0 || g.MaxPercentage > 0
+}
+
+type deletion struct {
+	kind string
+	name string
+	run  func() error
+}
+
+// String returns a description of the deletion (e.a. role 'jdoe')
+func (d deletion) String() string {
+	return fmt.Sprintf("%s '%s'", d.kind, d.name)
+}
+
+// remove runs a destructive statement right away, or postpones it when the mass deletion guard is enabled
+func (ph *Handler) remove(kind string, name string, run func() error) (err error) {
+	if !ph.guard.Enabled() {
+		return run()
+	}
+	log.Debugf("postponing removal of %s '%s' (mass_deletion_guard is enabled)", kind, name)
+	ph.deletions = append(ph.deletions, deletion{kind: kind, name: name, run: run})
+	return nil
+}
+
+// PlannedDeletions returns a description of all postponed removals
+func (ph *Handler) PlannedDeletions() (descriptions []string) {
+	for _, d := range ph.deletions {
+		descriptions = append(descriptions, d.String())
+	}
+	return descriptions
+}
+
+// CheckDeletions returns a description for every kind of object of which the postponed removals exceed the
+// thresholds of the mass deletion guard
+func (ph *Handler) CheckDeletions() (exceeded []string, err error) {
+	counts := make(map[string]int)
+	for _, d := range ph.deletions {
+		counts[d.kind]++
+	}
+	for _, kind := range deletionKinds {
+		count := counts[kind]
+		if count == 0 {
+			continue
+		}
+		qry, exists := deletionTotalQueries[kind]
+		if !exists {
+			if ph.guard.exceeds(count, -1) {
+				exceeded = append(exceeded, fmt.Sprintf("%d %s", count, kind))
+			}
+			continue
+		}
+		answer, err := ph.conn.runQueryGetOneField(qry)
+		if err != nil {
+			return nil, err
+		}
+		total, err := strconv.Atoi(answer)
+		if err != nil {
+			return nil, err
+		}
+		if ph.guard.exceeds(count, total) {
+			exceeded = append(exceeded, fmt.Sprintf("%d of %d %s (%.1f%%)", count, total, kind,
+				percentage(count, total)))
+		}
+	}
+	return exceeded, nil
+}
+
+func percentage(count int, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return 100 * float64(count) / float64(total)
+}
+
+// exceeds returns true if removing count of total objects exceeds any of the thresholds. With an unknown total (-1),
+// only MaxDeletions applies.
+func (g MassDeletionGuard) exceeds(count int, total int) bool {
+	if g.MaxDeletions > 0 && count > g.MaxDeletions {
+		return true
+	}
+	return total >= 0 && g.MaxPercentage > 0 && percentage(count, total) > g.MaxPercentage
+}
+
+// ApplyDeletions runs all postponed removals
+func (ph *Handler) ApplyDeletions() (err error) {
+	deletions := ph.deletions
+	ph.deletions = nil
+	for _, d := range deletions {
+		err = d.run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package pg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func init() {
+	Initialize(zap.NewNop().Sugar())
+}
+
+func TestGuardExceeds(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		guard    MassDeletionGuard
+		count    int
+		total    int
+		exceeds  bool
+		disabled bool
+	}{
+		{name: "disabled", count: 100, total: 100, disabled: true},
+		{name: "within max_deletions", guard: MassDeletionGuard{MaxDeletions: 10}, count: 10, total: 100},
+		{name: "exceeds max_deletions", guard: MassDeletionGuard{MaxDeletions: 10}, count: 11, total: 100,
+			exceeds: true},
+		{name: "within max_percentage", guard: MassDeletionGuard{MaxPercentage: 20}, count: 20, total: 100},
+		{name: "exceeds max_percentage", guard: MassDeletionGuard{MaxPercentage: 20}, count: 21, total: 100,
+			exceeds: true},
+		{name: "max_percentage without a total", guard: MassDeletionGuard{MaxPercentage: 20}, count: 5, total: -1},
+		{name: "max_deletions without a total", guard: MassDeletionGuard{MaxDeletions: 3, MaxPercentage: 20},
+			count: 5, total: -1, exceeds: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if enabled := test.guard.Enabled(); enabled == test.disabled {
+				t.Errorf("expected enabled to be %t", !test.disabled)
+			}
+			if exceeds := test.guard.exceeds(test.count, test.total); exceeds != test.exceeds {
+				t.Errorf("expected exceeds to be %t", test.exceeds)
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	var removed []string
+	removal := func(name string) func() error {
+		return func() error {
+			removed = append(removed, name)
+			return nil
+		}
+	}
+
+	ph := &Handler{}
+	if err := ph.remove(roleDeletions, "jdoe", removal("jdoe")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"jdoe"}) || len(ph.deletions) != 0 {
+		t.Fatalf("expected the removal to run right away without a guard, got %v", removed)
+	}
+
+	removed = nil
+	ph = &Handler{guard: MassDeletionGuard{MaxDeletions: 1}}
+	for _, d := range []deletion{
+		{kind: databaseDeletions, name: "app"},
+		{kind: tablespaceDeletions, name: "fast"},
+		{kind: foreignServerDeletions, name: "app.remote"},
+	} {
+		if err := ph.remove(d.kind, d.name, removal(d.name)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(removed) != 0 {
+		t.Fatalf("expected all removals to be postponed, got %v", removed)
+	}
+	expected := []string{"databases 'app'", "tablespaces 'fast'", "foreign servers 'app.remote'"}
+	if planned := ph.PlannedDeletions(); !reflect.DeepEqual(planned, expected) {
+		t.Errorf("expected planned deletions %v, got %v", expected, planned)
+	}
+	// Foreign servers have no total, so only max_deletions applies, and CheckDeletions runs no query
+	ph.deletions = ph.deletions[2:]
+	exceeded, err := ph.CheckDeletions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exceeded) != 0 {
+		t.Errorf("expected no thresholds to be exceeded for foreign servers, got %v", exceeded)
+	}
+	ph.deletions = append(ph.deletions, deletion{kind: foreignServerDeletions, name: "app.other",
+		run: removal("app.other")})
+	exceeded, err = ph.CheckDeletions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"2 foreign servers"}; !reflect.DeepEqual(exceeded, expected) {
+		t.Errorf("expected %v to be exceeded, got %v", expected, exceeded)
+	}
+	if err = ph.ApplyDeletions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"app.remote", "app.other"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected removals %v in order, got %v", expected, removed)
+	}
+	if len(ph.deletions) != 0 {
+		t.Errorf("expected no postponed removals after applying them")
+	}
+}
+
+func TestApplyDeletionsStopsOnError(t *testing.T) {
+	var removed []string
+	ph := &Handler{guard: MassDeletionGuard{MaxDeletions: 10}}
+	ph.deletions = []deletion{
+		{kind: roleDeletions, name: "a", run: func() error { removed = append(removed, "a"); return nil }},
+		{kind: roleDeletions, name: "b", run: func() error { return errors.New("failed") }},
+		{kind: roleDeletions, name: "c", run: func() error { removed = append(removed, "c"); return nil }},
+	}
+	if err := ph.ApplyDeletions(); err == nil {
+		t.Errorf("expected an error")
+	}
+	if !reflect.DeepEqual(removed, []string{"a"}) {
+		t.Errorf("expected only a to be removed, got %v", removed)
+	}
+}
@@ -1,38 +1,68 @@
 package pg
 
 type Handler struct {
-	conn          *Conn
-	strictOptions StrictOptions
-	databases     Databases
-	roles         Roles
-	slots         ReplicationSlots
+	conn              *Conn
+	strictOptions     StrictOptions
+	slotHealthOptions SlotHealthOptions
+	databases         Databases
+	roles             Roles
+	slots             ReplicationSlots
+	tablespaces       Tablespaces
+	guard             MassDeletionGuard
+	// deletions holds the removals that are postponed by the mass deletion guard
+	deletions []deletion
+	// dbConns holds the connections to databases that are not managed (see getDbConnection)
+	dbConns map[string]*Conn
 }
 
-func NewPgHandler(connParams Dsn, options StrictOptions, databases Databases, slots []string) (ph *Handler) {
-	ph = &Handler{
-		conn:          NewConn(connParams),
-		strictOptions: options,
-		databases:     databases,
-		roles:         make(Roles),
-		slots:         make(ReplicationSlots),
+func NewPgHandler(connParams Dsn, options StrictOptions, databases Databases, slots ReplicationSlots,
+	slotHealthOptions SlotHealthOptions, tablespaces Tablespaces, guard MassDeletionGuard) (ph *Handler) {
+	if tablespaces == nil {
+		tablespaces = make(Tablespaces)
+	}
+	if databases == nil {
+		databases = make(Databases)
 	}
-	for _, slotName := range slots {
-		slot := NewSlot(ph, slotName)
-		ph.slots[slotName] = *slot
+	if slots == nil {
+		slots = make(ReplicationSlots)
+	}
+	ph = &Handler{
+		conn:              NewConn(connParams),
+		strictOptions:     options,
+		slotHealthOptions: slotHealthOptions,
+		databases:         databases,
+		roles:             make(Roles),
+		slots:             slots,
+		tablespaces:       tablespaces,
+		guard:             guard,
+		dbConns:           make(map[string]*Conn),
 	}
 	ph.setDefaults()
 	return ph
 }
 
 func (ph *Handler) setDefaults() {
+	for name, ts := range ph.tablespaces {
+		if ts == nil {
+			ts = &Tablespace{}
+			ph.tablespaces[name] = ts
+		}
+		ts.handler = ph
+		ts.name = name
+	}
 	for name, db := range ph.databases {
 		db.handler = ph
 		db.name = name
 		db.SetDefaults()
 	}
 	for name, rs := range ph.slots {
+		if rs == nil {
+			rs = &ReplicationSlot{}
+			ph.slots[name] = rs
+		}
 		rs.handler = ph
 		rs.name = name
+		rs.SetDefaults()
 	}
 }
 
@@ -41,6 +71,21 @@ func (ph *Handler) GetDb(dbName string) (d *Database) {
 	return NewDatabase(ph, dbName, "")
 }
 
+// getDbConnection returns a connection to a database, without adding it to the managed databases. Connections to
+// databases that are not managed are kept, so that they are reused.
+func (ph *Handler) getDbConnection(dbName string) (c *Conn) {
+	if d, exists := ph.databases[dbName]; exists {
+		return d.GetDbConnection()
+	}
+	if c, exists := ph.dbConns[dbName]; exists {
+		return c
+	}
+	d := &Database{handler: ph, name: dbName}
+	c = d.GetDbConnection()
+	ph.dbConns[dbName] = c
+	return c
+}
+
 func (ph *Handler) GetRole(roleName string) (d *Role, err error) {
 	// NewDatabase does everything we need to do
 	return NewRole(ph, roleName, RoleOptions{}, Present)
@@ -72,6 +117,36 @@ func (ph *Handler) CreateOrDropDatabases() (err error) {
 	return nil
 }
 
+// CreateTablespaces creates all tablespaces that should be present. This should run before the databases are
+// created, as they can be created in these tablespaces.
+func (ph *Handler) CreateTablespaces() (err error) {
+	for _, ts := range ph.tablespaces {
+		if !ts.State.Bool() {
+			continue
+		}
+		err = ts.Create()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropTablespaces drops all tablespaces that should be absent. This should run after the databases are dropped, as
+// a tablespace can only be dropped when it is empty.
+func (ph *Handler) DropTablespaces() (err error) {
+	for _, ts := range ph.tablespaces {
+		if ts.State.Bool() {
+			continue
+		}
+		err = ts.Drop()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (ph *Handler) CreateOrDropSlots() (err error) {
 	for _, d := range ph.slots {
 		if d.State.Bool() {
@@ -96,3 +171,17 @@ func (ph *Handler) StrictifyDatabases() (err error) {
 func (ph *Handler) StrictifyExtensions() (err error) {
 	return nil
 }
+
+// CurrentUser returns the name of the role pgfga is connected as
+func (ph *Handler) CurrentUser() (userName string, err error) {
+	return ph.conn.runQueryGetOneField("SELECT current_user")
+}
+
+// RoleMembers returns the names of all roles that are a direct member of a role
+func (ph *Handler) RoleMembers(roleName string) (members []string, err error) {
+	qry := `select grantee.rolname from pg_auth_members auth inner join pg_roles
+		granted on auth.roleid = granted.oid inner join pg_roles
+		grantee on auth.member = grantee.oid where
+		granted.rolname = $1 and grantee.rolname != CURRENT_USER`
+	return ph.conn.runQueryGetOneColumn(qry, roleName)
+}
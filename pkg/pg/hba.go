@@ -0,0 +1,416 @@
+package pg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	managedBlockBegin = "# BEGIN pgfga managed block (do not edit, changes will be overwritten)"
+	managedBlockEnd   = "# END pgfga managed block"
+)
+
+// HbaConfig configures generation of pg_hba.conf (and pg_ident.conf) entries from the configured users
+type HbaConfig struct {
+	Enabled        bool              `yaml:"enabled"`
+	File           string            `yaml:"file"`
+	IdentFile      string            `yaml:"ident_file"`
+	WholeFile      bool              `yaml:"whole_file"`
+	ConnectionType string            `yaml:"connection_type"`
+	Addresses      []string          `yaml:"addresses"`
+	Databases      []string          `yaml:"databases"`
+	PasswordMethod string            `yaml:"password_method"`
+	LdapOptions    map[string]string `yaml:"ldap_options"`
+	GssOptions     map[string]string `yaml:"gss_options"`
+}
+
+// SetDefaults sets defaults for all options that are not set
+func (hc *HbaConfig) SetDefaults() {
+	if hc.ConnectionType == "" {
+		hc.ConnectionType = "host"
+	}
+	if len(hc.Addresses) == 0 {
+		hc.Addresses = []string{"all"}
+	}
+	if len(hc.Databases) == 0 {
+		hc.Databases = []string{"all"}
+	}
+	if hc.PasswordMethod == "" {
+		hc.PasswordMethod = "md5"
+	}
+}
+
+// HbaRule is one rule in pg_hba.conf, which is rendered as one line per address
+type HbaRule struct {
+	ConnectionType string
+	Databases      []string
+	// User is the user field as it should appear in pg_hba.conf (e.a. a username or +groupname)
+	User      string
+	Addresses []string
+	Method    string
+	Options   map[string]string
+}
+
+var simpleHbaToken = regexp.MustCompile(`^[a-zA-Z0-9_.]+$`)
+
+// hbaToken quotes a token (like a user or database name) when required
+func hbaToken(token string) (quoted string) {
+	if simpleHbaToken.MatchString(token) {
+		return token
+	}
+	return fmt.Sprintf("\"%s\"", strings.Replace(token, "\"", "\"\"", -1))
+}
+
+// HbaUser returns the user field for a role
+func HbaUser(roleName string) (user string) {
+	return hbaToken(roleName)
+}
+
+// HbaGroup returns the user field which matches all members of a role
+func HbaGroup(roleName string) (user string) {
+	return "+" + hbaToken(roleName)
+}
+
+func hbaOptions(options map[string]string) (rendered string) {
+	var names []string
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		value := options[name]
+		if !simpleHbaToken.MatchString(value) {
+			value = fmt.Sprintf("\"%s\"", strings.Replace(value, "\"", "\"\"", -1))
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, value))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Lines returns the rule as lines for pg_hba.conf
+func (r HbaRule) Lines() (lines []string) {
+	var databases []string
+	for _, db := range r.Databases {
+		databases = append(databases, hbaToken(db))
+	}
+	options := hbaOptions(r.Options)
+	addresses := r.Addresses
+	if r.ConnectionType == "local" {
+		addresses = []string{""}
+	}
+	for _, address := range addresses {
+		fields := []string{r.ConnectionType, strings.Join(databases, ","), r.User}
+		if address != "" {
+			fields = append(fields, address)
+		}
+		fields = append(fields, r.Method)
+		if options != "" {
+			fields = append(fields, options)
+		}
+		lines = append(lines, strings.Join(fields, "\t"))
+	}
+	return lines
+}
+
+// IdentMapping is one user name mapping in pg_ident.conf
+type IdentMapping struct {
+	Map string
+	// SystemUser is the external user name (e.a. the CN of a client certificate), or a regular expression when it
+	// starts with a slash
+	SystemUser string
+	PgUser     string
+}
+
+// Line returns the mapping as a line for pg_ident.conf
+func (im IdentMapping) Line() (line string) {
+	systemUser := hbaToken(im.SystemUser)
+	if strings.HasPrefix(im.SystemUser, "/") && !strings.ContainsAny(im.SystemUser, " \t\"") {
+		// A regular expression should be set as is
+		systemUser = im.SystemUser
+	}
+	return strings.Join([]string{hbaToken(im.Map), systemUser, hbaToken(im.PgUser)}, "\t")
+}
+
+// managedBlock returns the lines surrounded by the begin and end markers of the managed block
+func managedBlock(lines []string) (block string) {
+	return strings.Join(append(append([]string{managedBlockBegin}, lines...), managedBlockEnd), "\n") + "\n"
+}
+
+// replaceManagedBlock replaces the managed block in content with the lines, or adds it on top when not present
+func replaceManagedBlock(content string, lines []string) (newContent string) {
+	block := managedBlock(lines)
+	begin := strings.Index(content, managedBlockBegin)
+	end := strings.Index(content, managedBlockEnd)
+	if begin < 0 || end < begin {
+		return block + content
+	}
+	end += len(managedBlockEnd)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+	return content[:begin] + block + content[end:]
+}
+
+// managedBlockLines returns the first and last line number (starting at 1) of the managed block in content, or 0 and
+// 0 when content has no managed block
+func managedBlockLines(content string) (first int, last int) {
+	for i, line := range strings.Split(content, "\n") {
+		switch {
+		case first == 0 && line == managedBlockBegin:
+			first = i + 1
+		case first > 0 && line == managedBlockEnd:
+			return first, i + 1
+		}
+	}
+	if first == 0 {
+		return 0, 0
+	}
+	// A managed block without end marker runs to the end of the file
+	return first, strings.Count(content, "\n") + 1
+}
+
+// applyConfFile writes the lines to a config file (as a managed block, or as the whole file with the lines in keep on
+// top), validates the result with validateQry (which should return the errors in the file), and restores the
+// original file when invalid.
+func (ph *Handler) applyConfFile(fileName string, lines []string, wholeFile bool, keep []string,
+	validateQry string) (changed bool, err error) {
+	// Reading and writing the config files of postgres is the whole point
+	// #nosec
+	original, err := os.ReadFile(fileName)
+	if err != nil {
+		return false, err
+	}
+	var newContent string
+	if wholeFile {
+		newContent = managedBlock(lines)
+		if len(keep) > 0 {
+			newContent = strings.Join(keep, "\n") + "\n" + newContent
+		}
+	} else {
+		newContent = replaceManagedBlock(string(original), lines)
+	}
+	if newContent == string(original) {
+		log.Debugf("%s is up to date", fileName)
+		return false, nil
+	}
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		return false, err
+	}
+	err = os.WriteFile(fileName, []byte(newContent), fi.Mode())
+	if err != nil {
+		return false, err
+	}
+	errors, err := ph.conn.runQueryGetOneColumn(validateQry)
+	if err == nil && len(errors) == 0 {
+		log.Infof("Succesfully updated %s", fileName)
+		return true, nil
+	}
+	restoreErr := os.WriteFile(fileName, original, fi.Mode())
+	if restoreErr != nil {
+		return false, fmt.Errorf("could not restore %s after failed validation: %v", fileName, restoreErr)
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, fmt.Errorf("generated %s is invalid (restored original): %s", fileName, strings.Join(errors, "; "))
+}
+
+// confFile returns the config file of postgres for a setting (hba_file or ident_file). A configured file should be
+// that same file, as the views that validate the result (like pg_hba_file_rules) only read the file of postgres.
+func (ph *Handler) confFile(configured string, setting string) (fileName string, err error) {
+	fileName, err = ph.conn.runQueryGetOneField("SHOW " + setting)
+	if err != nil {
+		return "", err
+	}
+	if configured == "" || filepath.Clean(configured) == filepath.Clean(fileName) {
+		return fileName, nil
+	}
+	configuredInfo, err := os.Stat(configured)
+	if err != nil {
+		return "", err
+	}
+	if fileInfo, err := os.Stat(fileName); err == nil && os.SameFile(configuredInfo, fileInfo) {
+		return fileName, nil
+	}
+	return "", fmt.Errorf("%s is not the %s of postgres (%s) and cannot be validated", configured, setting, fileName)
+}
+
+// hbaFileRule is a rule as read from pg_hba_file_rules
+type hbaFileRule struct {
+	Line      int      `json:"line"`
+	Type      string   `json:"type"`
+	Databases []string `json:"databases"`
+	Address   string   `json:"address"`
+	Netmask   string   `json:"netmask"`
+	Method    string   `json:"method"`
+	Options   []string `json:"options"`
+}
+
+// hbaRule converts the rule into an HbaRule for a user
+func (fr hbaFileRule) hbaRule(userName string) (rule HbaRule) {
+	rule = HbaRule{
+		ConnectionType: fr.Type,
+		Databases:      fr.Databases,
+		User:           HbaUser(userName),
+		Addresses:      []string{hbaAddress(fr.Address, fr.Netmask)},
+		Method:         fr.Method,
+		Options:        make(map[string]string),
+	}
+	for _, option := range fr.Options {
+		pair := strings.SplitN(option, "=", 2)
+		if len(pair) == 2 {
+			rule.Options[pair[0]] = pair[1]
+		}
+	}
+	return rule
+}
+
+// hbaAddress returns the address field for an address and netmask as shown in pg_hba_file_rules (e.a. 10.0.0.0/8
+// for 10.0.0.0 and 255.0.0.0)
+func hbaAddress(address string, netmask string) (field string) {
+	if netmask == "" {
+		return address
+	}
+	ip := net.ParseIP(netmask)
+	if ip == nil {
+		return address
+	}
+	mask := net.IPMask(ip)
+	if ip4 := ip.To4(); ip4 != nil && strings.Contains(address, ".") {
+		mask = net.IPMask(ip4)
+	}
+	ones, bits := mask.Size()
+	if bits == 0 {
+		return address
+	}
+	return fmt.Sprintf("%s/%d", address, ones)
+}
+
+// connectionHbaLines returns the lines to keep on top of pg_hba.conf when managing the whole file, so that the user
+// of the pgfga connection keeps the access it has. These are all rules outside of the managed block that apply to
+// this user (directly, as member of a role, or with all), rendered for this user only.
+func (ph *Handler) connectionHbaLines(fileName string) (lines []string, err error) {
+	// Reading the config files of postgres is the whole point
+	// #nosec
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	first, last := managedBlockLines(string(content))
+	userName, err := ph.conn.runQueryGetOneField("SELECT current_user")
+	if err != nil {
+		return nil, err
+	}
+	answers, err := ph.conn.runQueryGetOneColumn(`SELECT json_build_object('line', line_number, 'type', type,
+		'databases', database, 'address', coalesce(address, ''), 'netmask', coalesce(netmask, ''),
+		'method', auth_method, 'options', options)::text
+		FROM pg_hba_file_rules WHERE error IS NULL AND EXISTS (SELECT FROM unnest(user_name) usr
+		WHERE usr IN ('all', current_user) OR (usr LIKE '+%' AND substr(usr, 2) IN
+		(SELECT rolname FROM pg_roles WHERE pg_has_role(current_user, oid, 'member'))))
+		ORDER BY line_number`)
+	if err != nil {
+		return nil, err
+	}
+	for _, answer := range answers {
+		var fileRule hbaFileRule
+		err = json.Unmarshal([]byte(answer), &fileRule)
+		if err != nil {
+			return nil, err
+		}
+		if first > 0 && fileRule.Line >= first && fileRule.Line <= last {
+			continue
+		}
+		lines = append(lines, fileRule.hbaRule(userName).Lines()...)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("refusing to manage the whole %s (no rule gives access to the pgfga user %s)",
+			fileName, userName)
+	}
+	comment := fmt.Sprintf("# Access of the pgfga user %s (kept by pgfga, as the whole file is managed)", userName)
+	return append([]string{comment}, lines...), nil
+}
+
+// reloadConf signals postgres to reload its configuration files
+func (ph *Handler) reloadConf() (err error) {
+	err = ph.conn.runQueryExec("SELECT pg_reload_conf()")
+	if err != nil {
+		return err
+	}
+	log.Infof("Succesfully reloaded postgres configuration")
+	return nil
+}
+
+// applyIdent renders the mappings into pg_ident.conf and validates the result with pg_ident_file_mappings (on
+// PostgreSQL 15 and newer). The file is left alone when there are no mappings and it has no managed block yet.
+func (ph *Handler) applyIdent(config HbaConfig, mappings []IdentMapping) (changed bool, err error) {
+	fileName, err := ph.confFile(config.IdentFile, "ident_file")
+	if err != nil {
+		return false, err
+	}
+	if len(mappings) == 0 {
+		// Reading the config files of postgres is the whole point
+		// #nosec
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return false, err
+		}
+		if !strings.Contains(string(content), managedBlockBegin) {
+			return false, nil
+		}
+	}
+	var lines []string
+	for _, mapping := range mappings {
+		lines = append(lines, mapping.Line())
+	}
+	version, err := ph.conn.ServerVersionNum()
+	if err != nil {
+		return false, err
+	}
+	validateQry := "SELECT '' WHERE false"
+	if version >= 150000 {
+		validateQry = `SELECT 'line ' || line_number || ': ' || error FROM pg_ident_file_mappings
+			WHERE error IS NOT NULL`
+	}
+	return ph.applyConfFile(fileName, lines, config.WholeFile, nil, validateQry)
+}
+
+// ApplyHba renders the rules into pg_hba.conf and the mappings into pg_ident.conf, validates the result with
+// pg_hba_file_rules and reloads postgres
+func (ph *Handler) ApplyHba(config HbaConfig, rules []HbaRule, mappings []IdentMapping) (err error) {
+	identChanged, err := ph.applyIdent(config, mappings)
+	if err != nil {
+		return err
+	}
+	fileName, err := ph.confFile(config.File, "hba_file")
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, rule := range rules {
+		lines = append(lines, rule.Lines()...)
+	}
+	var keep []string
+	if config.WholeFile {
+		keep, err = ph.connectionHbaLines(fileName)
+		if err != nil {
+			return err
+		}
+	}
+	validateQry := `SELECT 'line ' || line_number || ': ' || error FROM pg_hba_file_rules WHERE error IS NOT NULL`
+	hbaChanged, err := ph.applyConfFile(fileName, lines, config.WholeFile, keep, validateQry)
+	if err != nil {
+		return err
+	}
+	if !identChanged && !hbaChanged {
+		return nil
+	}
+	return ph.reloadConf()
+}
@@ -0,0 +1,149 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHbaRuleLines(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		rule     HbaRule
+		expected []string
+	}{
+		{
+			name: "password user",
+			rule: HbaRule{ConnectionType: "host", Databases: []string{"all"}, User: HbaUser("jdoe"),
+				Addresses: []string{"all"}, Method: "md5"},
+			expected: []string{"host\tall\tjdoe\tall\tmd5"},
+		},
+		{
+			name: "line per address",
+			rule: HbaRule{ConnectionType: "hostssl", Databases: []string{"fga", "other"}, User: HbaUser("jdoe"),
+				Addresses: []string{"10.0.0.0/8", "192.168.0.0/16"}, Method: "cert"},
+			expected: []string{
+				"hostssl\tfga,other\tjdoe\t10.0.0.0/8\tcert",
+				"hostssl\tfga,other\tjdoe\t192.168.0.0/16\tcert",
+			},
+		},
+		{
+			name: "local without address",
+			rule: HbaRule{ConnectionType: "local", Databases: []string{"all"}, User: HbaUser("postgres"),
+				Addresses: []string{"all"}, Method: "peer"},
+			expected: []string{"local\tall\tpostgres\tpeer"},
+		},
+		{
+			name: "group with quoting and options",
+			rule: HbaRule{ConnectionType: "host", Databases: []string{"my db"}, User: HbaGroup("Dba Team"),
+				Addresses: []string{"all"}, Method: "ldap",
+				Options: map[string]string{"ldapserver": "ldap", "ldapsuffix": ",ou=users,dc=pgfga,dc=org"}},
+			expected: []string{
+				"host\t\"my db\"\t+\"Dba Team\"\tall\tldap\tldapserver=ldap ldapsuffix=\",ou=users,dc=pgfga,dc=org\"",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if lines := test.rule.Lines(); !reflect.DeepEqual(lines, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, lines)
+			}
+		})
+	}
+}
+
+func TestIdentMappingLine(t *testing.T) {
+	for _, test := range []struct {
+		mapping  IdentMapping
+		expected string
+	}{
+		{IdentMapping{Map: "pgfga_cert", SystemUser: "jdoe", PgUser: "jdoe"}, "pgfga_cert\tjdoe\tjdoe"},
+		{IdentMapping{Map: "pgfga_gss", SystemUser: "jdoe@EXAMPLE.COM", PgUser: "jdoe"},
+			"pgfga_gss\t\"jdoe@EXAMPLE.COM\"\tjdoe"},
+		{IdentMapping{Map: "pgfga_gss", SystemUser: `/^(.*)@EXAMPLE\.COM$`, PgUser: `\1`},
+			"pgfga_gss\t/^(.*)@EXAMPLE\\.COM$\t\"\\1\""},
+	} {
+		if line := test.mapping.Line(); line != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, line)
+		}
+	}
+}
+
+func TestReplaceManagedBlock(t *testing.T) {
+	block := managedBlock([]string{"new"})
+	for _, test := range []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "empty file", content: "", expected: block},
+		{name: "added on top", content: "local all all peer\n", expected: block + "local all all peer\n"},
+		{
+			name:     "replaced in place",
+			content:  "# top\n" + managedBlock([]string{"old"}) + "local all all peer\n",
+			expected: "# top\n" + block + "local all all peer\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if content := replaceManagedBlock(test.content, []string{"new"}); content != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, content)
+			}
+		})
+	}
+}
+
+func TestManagedBlockLines(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		content     string
+		first, last int
+	}{
+		{name: "no block", content: "local all all peer\n"},
+		{name: "block", content: "# kept\n" + managedBlock([]string{"a", "b"}) + "local all all peer\n",
+			first: 2, last: 5},
+		{name: "block without end", content: managedBlockBegin + "\na\nb\n", first: 1, last: 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			first, last := managedBlockLines(test.content)
+			if first != test.first || last != test.last {
+				t.Errorf("expected %d-%d, got %d-%d", test.first, test.last, first, last)
+			}
+		})
+	}
+}
+
+func TestHbaFileRule(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		rule     hbaFileRule
+		expected []string
+	}{
+		{
+			name:     "local",
+			rule:     hbaFileRule{Type: "local", Databases: []string{"all"}, Method: "peer"},
+			expected: []string{"local\tall\tpostgres\tpeer"},
+		},
+		{
+			name: "ipv4 netmask",
+			rule: hbaFileRule{Type: "host", Databases: []string{"all"}, Address: "10.0.0.0",
+				Netmask: "255.0.0.0", Method: "scram-sha-256"},
+			expected: []string{"host\tall\tpostgres\t10.0.0.0/8\tscram-sha-256"},
+		},
+		{
+			name: "ipv6 netmask",
+			rule: hbaFileRule{Type: "host", Databases: []string{"replication"}, Address: "::1",
+				Netmask: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", Method: "trust"},
+			expected: []string{"host\treplication\tpostgres\t::1/128\ttrust"},
+		},
+		{
+			name: "hostname with options",
+			rule: hbaFileRule{Type: "hostssl", Databases: []string{"all"}, Address: "samenet", Method: "cert",
+				Options: []string{"map=pgfga_cert", "clientcert=verify-full"}},
+			expected: []string{"hostssl\tall\tpostgres\tsamenet\tcert\tclientcert=\"verify-full\" map=pgfga_cert"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if lines := test.rule.hbaRule("postgres").Lines(); !reflect.DeepEqual(lines, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, lines)
+			}
+		})
+	}
+}
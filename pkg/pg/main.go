@@ -18,10 +18,14 @@ var InvalidOption = errors.New("invalid role option")
 type Dsn map[string]string
 
 type StrictOptions struct {
-	Users      bool `yaml:"users"`
-	Databases  bool `yaml:"databases"`
-	Extensions bool `yaml:"extensions"`
-	Slots      bool `yaml:"replication_slots"`
+	Users          bool `yaml:"users"`
+	Databases      bool `yaml:"databases"`
+	Extensions     bool `yaml:"extensions"`
+	Slots          bool `yaml:"replication_slots"`
+	Publications   bool `yaml:"publications"`
+	Subscriptions  bool `yaml:"subscriptions"`
+	Tablespaces    bool `yaml:"tablespaces"`
+	ForeignServers bool `yaml:"foreign_servers"`
 }
 
 // identifier returns the object name ready to be used in a sql query as an object name (e.a. select * from %s)
@@ -38,3 +42,42 @@ func quotedSqlValue(objectName string) (escaped string) {
 func connectStringValue(objectName string) (escaped string) {
 	return fmt.Sprintf("'%s'", strings.Replace(objectName, "'", "\\'", -1))
 }
+
+// qualifiedIdentifier returns a (optionally schema qualified) object name ready to be used in a sql query.
+// Names without a schema are expected to live in the public schema.
+func qualifiedIdentifier(objectName string) (escaped string) {
+	parts := strings.SplitN(objectName, ".", 2)
+	if len(parts) == 1 {
+		return fmt.Sprintf("%s.%s", identifier("public"), identifier(parts[0]))
+	}
+	return fmt.Sprintf("%s.%s", identifier(parts[0]), identifier(parts[1]))
+}
+
+// qualifiedName returns the name of an object in the form schema.name (public is added when no schema is set)
+func qualifiedName(objectName string) (name string) {
+	if strings.Contains(objectName, ".") {
+		return objectName
+	}
+	return "public." + objectName
+}
+
+// listDiff returns the items that are in want but not in have (missing) and the ones in have but not in want (extra)
+func listDiff(want []string, have []string) (missing []string, extra []string) {
+	haveMap := make(map[string]bool)
+	for _, item := range have {
+		haveMap[item] = true
+	}
+	wantMap := make(map[string]bool)
+	for _, item := range want {
+		wantMap[item] = true
+		if !haveMap[item] {
+			missing = append(missing, item)
+		}
+	}
+	for _, item := range have {
+		if !wantMap[item] {
+			extra = append(extra, item)
+		}
+	}
+	return missing, extra
+}
@@ -0,0 +1,97 @@
+package pg
+
+import (
+	"fmt"
+	"time"
+)
+
+// orphanedSinceSetting is a custom role setting which records since when a role is orphaned (e.a. no longer a member
+// of any ldap group), so that it can be dropped after a grace period
+const orphanedSinceSetting = "pgfga.orphaned_since"
+
+// fromLdapSetting is a custom role setting which marks the roles that pgfga created from ldap, so that only those
+// are revoked from group roles and deprovisioned when they depart from ldap
+const fromLdapSetting = "pgfga.from_ldap"
+
+// SetFromLdap marks the role as created from ldap
+func (r Role) SetFromLdap() (err error) {
+	return r.SetSetting(fromLdapSetting, "on")
+}
+
+// FromLdapRoles returns the names of all roles that are marked as created from ldap
+func (ph *Handler) FromLdapRoles() (roleNames []string, err error) {
+	qry := `SELECT r.rolname FROM pg_db_role_setting s INNER JOIN pg_roles r ON s.setrole = r.oid,
+		unnest(s.setconfig) setting WHERE s.setdatabase = 0 AND setting = $1`
+	return ph.conn.runQueryGetOneColumn(qry, fromLdapSetting+"=on")
+}
+
+// OrphanedRoles returns the names of all roles that are marked as orphaned
+func (ph *Handler) OrphanedRoles() (roleNames []string, err error) {
+	qry := `SELECT r.rolname FROM pg_db_role_setting s INNER JOIN pg_roles r ON s.setrole = r.oid,
+		unnest(s.setconfig) setting WHERE s.setdatabase = 0 AND setting LIKE $1`
+	return ph.conn.runQueryGetOneColumn(qry, orphanedSinceSetting+"=%")
+}
+
+// OrphanedSince returns since when the role is marked as orphaned, or the zero time if it is not
+func (r Role) OrphanedSince() (since time.Time, err error) {
+	qry := `SELECT substr(setting, length($2) + 2) FROM pg_db_role_setting s INNER JOIN pg_roles r ON s.setrole = r.oid,
+		unnest(s.setconfig) setting WHERE r.rolname = $1 AND s.setdatabase = 0 AND setting LIKE $2 || '=%'`
+	values, err := r.handler.conn.runQueryGetOneColumn(qry, r.name, orphanedSinceSetting)
+	if err != nil || len(values) == 0 {
+		return time.Time{}, err
+	}
+	since, err = time.Parse(time.RFC3339, values[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s for role %s: %v", orphanedSinceSetting, r.name, err)
+	}
+	return since, nil
+}
+
+// SetOrphaned marks the role as orphaned since now, unless it already is marked as orphaned
+func (r Role) SetOrphaned() (since time.Time, err error) {
+	since, err = r.OrphanedSince()
+	if err != nil || !since.IsZero() {
+		return since, err
+	}
+	since = time.Now().UTC().Truncate(time.Second)
+	err = r.handler.conn.runQueryExec(fmt.Sprintf("ALTER ROLE %s SET %s = %s", identifier(r.name),
+		orphanedSinceSetting, quotedSqlValue(since.Format(time.RFC3339))))
+	if err != nil {
+		return time.Time{}, err
+	}
+	log.Infof("Role '%s' succesfully marked as orphaned", r.name)
+	return since, nil
+}
+
+// ResetOrphaned removes the orphaned mark from the role
+func (r Role) ResetOrphaned() (err error) {
+	since, err := r.OrphanedSince()
+	if err != nil || since.IsZero() {
+		return err
+	}
+	err = r.handler.conn.runQueryExec(fmt.Sprintf("ALTER ROLE %s RESET %s", identifier(r.name),
+		orphanedSinceSetting))
+	if err != nil {
+		return err
+	}
+	log.Infof("Role '%s' is no longer marked as orphaned", r.name)
+	return nil
+}
+
+// SetNoLogin disables login for the role
+func (r Role) SetNoLogin() (err error) {
+	return r.setRoleOption(LoginOption.Inverse())
+}
+
+// Deprovision disables login for the role and marks it as orphaned. Since this locks the user out, it is postponed
+// like a removal when the mass deletion guard is enabled.
+func (r Role) Deprovision() (err error) {
+	return r.handler.remove(loginDeletions, r.name, func() error {
+		err := r.SetNoLogin()
+		if err != nil {
+			return err
+		}
+		_, err = r.SetOrphaned()
+		return err
+	})
+}
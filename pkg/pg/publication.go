@@ -0,0 +1,248 @@
+package pg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Publications map[string]*Publication
+
+type Publication struct {
+	// name and db are set by the database
+	db                      *Database
+	name                    string
+	AllTables               bool     `yaml:"all_tables"`
+	Tables                  []string `yaml:"tables"`
+	Schemas                 []string `yaml:"schemas"`
+	Publish                 []string `yaml:"publish"`
+	PublishViaPartitionRoot bool     `yaml:"publish_via_partition_root"`
+	State                   State    `yaml:"state"`
+}
+
+var validPublishOperations = map[string]bool{
+	"insert":   true,
+	"update":   true,
+	"delete":   true,
+	"truncate": true,
+}
+
+func (p Publication) validate() (err error) {
+	if p.AllTables && (len(p.Tables) > 0 || len(p.Schemas) > 0) {
+		return fmt.Errorf("publication %s cannot have all_tables combined with tables or schemas", p.name)
+	}
+	for _, operation := range p.Publish {
+		if _, exists := validPublishOperations[strings.ToLower(operation)]; !exists {
+			return fmt.Errorf("invalid publish operation %s for publication %s (should be one of insert, update, "+
+				"delete, truncate)", operation, p.name)
+		}
+	}
+	return nil
+}
+
+// publishOperations returns the configured operations, or all operations when none are configured
+func (p Publication) publishOperations() (operations []string) {
+	if len(p.Publish) == 0 {
+		return []string{"insert", "update", "delete", "truncate"}
+	}
+	for _, operation := range p.Publish {
+		operations = append(operations, strings.ToLower(operation))
+	}
+	return operations
+}
+
+func (p Publication) withClause() (with string) {
+	return fmt.Sprintf("WITH (publish = %s, publish_via_partition_root = %t)",
+		quotedSqlValue(strings.Join(p.publishOperations(), ", ")), p.PublishViaPartitionRoot)
+}
+
+func (p *Publication) Drop() (err error) {
+	if !p.db.handler.strictOptions.Publications {
+		log.Infof("not dropping publication '%s'.'%s' (config.strict.publications is not True)", p.db.name, p.name)
+		return nil
+	}
+	c := p.db.GetDbConnection()
+	exists, err := c.runQueryExists("SELECT pubname FROM pg_publication WHERE pubname = $1", p.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	err = p.db.handler.remove(publicationDeletions, p.db.name+"."+p.name, func() (err error) {
+		err = c.runQueryExec("DROP PUBLICATION " + identifier(p.name))
+		if err != nil {
+			return err
+		}
+		log.Infof("Publication '%s'.'%s' succesfully dropped.", p.db.name, p.name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.State = Absent
+	return nil
+}
+
+func (p Publication) Create() (err error) {
+	err = p.validate()
+	if err != nil {
+		return err
+	}
+	c := p.db.GetDbConnection()
+	version, err := c.ServerVersionNum()
+	if err != nil {
+		return err
+	}
+	if len(p.Schemas) > 0 && version < 150000 {
+		return fmt.Errorf("publication %s has schemas, which requires PostgreSQL 15 or newer", p.name)
+	}
+	exists, err := c.runQueryExists("SELECT pubname FROM pg_publication WHERE pubname = $1", p.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		var objects []string
+		if len(p.Tables) > 0 {
+			var tables []string
+			for _, table := range p.Tables {
+				tables = append(tables, qualifiedIdentifier(table))
+			}
+			objects = append(objects, "TABLE "+strings.Join(tables, ", "))
+		}
+		if len(p.Schemas) > 0 {
+			var schemas []string
+			for _, schema := range p.Schemas {
+				schemas = append(schemas, identifier(schema))
+			}
+			objects = append(objects, "TABLES IN SCHEMA "+strings.Join(schemas, ", "))
+		}
+		createQry := "CREATE PUBLICATION " + identifier(p.name)
+		if p.AllTables {
+			createQry += " FOR ALL TABLES"
+		} else if len(objects) > 0 {
+			createQry += " FOR " + strings.Join(objects, ", ")
+		}
+		err = c.runQueryExec(createQry + " " + p.withClause())
+		if err != nil {
+			return err
+		}
+		log.Infof("Publication '%s'.'%s' succesfully created.", p.db.name, p.name)
+		return nil
+	}
+	exists, err = c.runQueryExists("SELECT pubname FROM pg_publication WHERE pubname = $1 AND puballtables = $2",
+		p.name, p.AllTables)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		log.Warnf("Publication '%s'.'%s' exists, but all_tables differs from config (all_tables: %t). "+
+			"Drop the publication to have it recreated.", p.db.name, p.name, p.AllTables)
+		return nil
+	}
+	err = p.alterOptions()
+	if err != nil {
+		return err
+	}
+	if p.AllTables {
+		return nil
+	}
+	err = p.alterTables()
+	if err != nil {
+		return err
+	}
+	if version < 150000 {
+		return nil
+	}
+	return p.alterSchemas()
+}
+
+func (p Publication) alterOptions() (err error) {
+	c := p.db.GetDbConnection()
+	operations := p.publishOperations()
+	sort.Strings(operations)
+	qry := `SELECT array_to_string(ARRAY(SELECT op FROM (VALUES
+			('insert', pubinsert), ('update', pubupdate), ('delete', pubdelete), ('truncate', pubtruncate)) ops(op, enabled)
+			WHERE enabled ORDER BY op), ', ')
+		FROM pg_publication WHERE pubname = $1 AND pubviaroot = $2`
+	current, err := c.runQueryGetOneField(fmt.Sprintf("SELECT COALESCE((%s), '')", qry), p.name,
+		p.PublishViaPartitionRoot)
+	if err != nil {
+		return err
+	}
+	if current == strings.Join(operations, ", ") {
+		return nil
+	}
+	err = c.runQueryExec(fmt.Sprintf("ALTER PUBLICATION %s SET (publish = %s, publish_via_partition_root = %t)",
+		identifier(p.name), quotedSqlValue(strings.Join(operations, ", ")), p.PublishViaPartitionRoot))
+	if err != nil {
+		return err
+	}
+	log.Infof("Publication '%s'.'%s' succesfully altered with publish options", p.db.name, p.name)
+	return nil
+}
+
+func (p Publication) alterTables() (err error) {
+	c := p.db.GetDbConnection()
+	qry := `SELECT n.nspname||'.'||cl.relname FROM pg_publication_rel pr
+		INNER JOIN pg_publication pub ON pr.prpubid = pub.oid
+		INNER JOIN pg_class cl ON pr.prrelid = cl.oid
+		INNER JOIN pg_namespace n ON cl.relnamespace = n.oid
+		WHERE pub.pubname = $1`
+	current, err := c.runQueryGetOneColumn(qry, p.name)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for _, table := range p.Tables {
+		tables = append(tables, qualifiedName(table))
+	}
+	missing, extra := listDiff(tables, current)
+	for _, table := range missing {
+		err = c.runQueryExec(fmt.Sprintf("ALTER PUBLICATION %s ADD TABLE %s", identifier(p.name),
+			qualifiedIdentifier(table)))
+		if err != nil {
+			return err
+		}
+		log.Infof("Table '%s' succesfully added to publication '%s'.'%s'", table, p.db.name, p.name)
+	}
+	for _, table := range extra {
+		err = c.runQueryExec(fmt.Sprintf("ALTER PUBLICATION %s DROP TABLE %s", identifier(p.name),
+			qualifiedIdentifier(table)))
+		if err != nil {
+			return err
+		}
+		log.Infof("Table '%s' succesfully removed from publication '%s'.'%s'", table, p.db.name, p.name)
+	}
+	return nil
+}
+
+func (p Publication) alterSchemas() (err error) {
+	c := p.db.GetDbConnection()
+	qry := `SELECT n.nspname FROM pg_publication_namespace pn
+		INNER JOIN pg_publication pub ON pn.pnpubid = pub.oid
+		INNER JOIN pg_namespace n ON pn.pnnspid = n.oid
+		WHERE pub.pubname = $1`
+	current, err := c.runQueryGetOneColumn(qry, p.name)
+	if err != nil {
+		return err
+	}
+	missing, extra := listDiff(p.Schemas, current)
+	for _, schema := range missing {
+		err = c.runQueryExec(fmt.Sprintf("ALTER PUBLICATION %s ADD TABLES IN SCHEMA %s", identifier(p.name),
+			identifier(schema)))
+		if err != nil {
+			return err
+		}
+		log.Infof("Schema '%s' succesfully added to publication '%s'.'%s'", schema, p.db.name, p.name)
+	}
+	for _, schema := range extra {
+		err = c.runQueryExec(fmt.Sprintf("ALTER PUBLICATION %s DROP TABLES IN SCHEMA %s", identifier(p.name),
+			identifier(schema)))
+		if err != nil {
+			return err
+		}
+		log.Infof("Schema '%s' succesfully removed from publication '%s'.'%s'", schema, p.db.name, p.name)
+	}
+	return nil
+}
@@ -1,29 +1,136 @@
 package pg
 
-type ReplicationSlots map[string]ReplicationSlot
+import (
+	"fmt"
+	"strings"
+)
+
+// SlotType represents the type of a replication slot (physical or logical)
+type SlotType string
+
+const (
+	// PhysicalSlot is a slot used for streaming replication
+	PhysicalSlot SlotType = "physical"
+	// LogicalSlot is a slot used for logical decoding with an output plugin
+	LogicalSlot SlotType = "logical"
+
+	defaultSlotPlugin = "pgoutput"
+)
+
+// UnmarshalYAML converts a yaml string to the enum value
+func (st *SlotType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	switch SlotType(strings.ToLower(str)) {
+	case PhysicalSlot, "":
+		*st = PhysicalSlot
+	case LogicalSlot:
+		*st = LogicalSlot
+	default:
+		return fmt.Errorf("invalid slot type %s (should be physical or logical)", str)
+	}
+	return nil
+}
+
+type ReplicationSlots map[string]*ReplicationSlot
+
+// UnmarshalYAML allows replication slots to be defined as a map, but also as a list of names (physical slots)
+func (rss *ReplicationSlots) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var names []string
+	if err := unmarshal(&names); err == nil {
+		*rss = make(ReplicationSlots)
+		for _, name := range names {
+			(*rss)[name] = &ReplicationSlot{}
+		}
+		return nil
+	}
+	var slots map[string]*ReplicationSlot
+	if err := unmarshal(&slots); err != nil {
+		return err
+	}
+	*rss = slots
+	return nil
+}
 
 type ReplicationSlot struct {
-	handler *Handler
-	name    string
-	State   State `yaml:"state"`
+	// for slots created from yaml, handler and name are set by the pg.Handler
+	handler    *Handler
+	name       string
+	Type       SlotType `yaml:"type"`
+	Plugin     string   `yaml:"plugin"`
+	Database   string   `yaml:"database"`
+	TwoPhase   bool     `yaml:"two_phase"`
+	Failover   bool     `yaml:"failover"`
+	ReserveWal bool     `yaml:"reserve_wal"`
+	State      State    `yaml:"state"`
+	// Temporary is rejected: a temporary slot is dropped when pgfga disconnects, and would be recreated every run
+	Temporary bool `yaml:"temporary"`
 }
 
 func NewSlot(handler *Handler, name string) (rs *ReplicationSlot) {
 	if rs, exists := handler.slots[name]; exists {
-		return &rs
+		return rs
 	}
 	rs = &ReplicationSlot{
 		handler: handler,
 		name:    name,
 		State:   Present,
 	}
-	handler.slots[name] = *rs
+	rs.SetDefaults()
+	handler.slots[name] = rs
 	return rs
 }
 
+// SetDefaults is called to set all defaults for slots created from yaml
+func (rs *ReplicationSlot) SetDefaults() {
+	if rs.Type == "" {
+		rs.Type = PhysicalSlot
+	}
+	if rs.Type != LogicalSlot {
+		return
+	}
+	if rs.Plugin == "" {
+		rs.Plugin = defaultSlotPlugin
+	}
+	if rs.Database == "" {
+		rs.Database = rs.handler.conn.DbName()
+	}
+}
+
+func (rs ReplicationSlot) validate() (err error) {
+	if rs.Temporary {
+		return fmt.Errorf("replication slot %s cannot be temporary (it would be dropped when pgfga disconnects)",
+			rs.name)
+	}
+	if rs.Type == LogicalSlot {
+		if rs.ReserveWal {
+			return fmt.Errorf("reserve_wal cannot be set for logical replication slot %s", rs.name)
+		}
+		return nil
+	}
+	if rs.Plugin != "" || rs.Database != "" {
+		return fmt.Errorf("plugin and database can only be set for logical replication slot %s", rs.name)
+	}
+	if rs.TwoPhase || rs.Failover {
+		return fmt.Errorf("two_phase and failover can only be set for logical replication slot %s", rs.name)
+	}
+	return nil
+}
+
+// slotConn returns the connection to use for managing the slot.
+// Logical slots are bound to a database and should be managed from a connection to that database.
+func (rs ReplicationSlot) slotConn(dbName string) (c *Conn) {
+	if dbName == "" {
+		return rs.handler.conn
+	}
+	return rs.handler.GetDb(dbName).GetDbConnection()
+}
+
 func (rs ReplicationSlot) Drop() (err error) {
 	ph := rs.handler
-	if ! ph.strictOptions.Slots {
+	if !ph.strictOptions.Slots {
 		log.Infof("skipping drop of replication slot %s (not running with strict option for slots", rs.name)
 		return nil
 	}
@@ -31,29 +138,115 @@ func (rs ReplicationSlot) Drop() (err error) {
 	if err != nil {
 		return err
 	}
-	if exists {
-		err = ph.conn.runQueryExec("SELECT pg_drop_physical_replication_slot($1)", rs.name)
+	if !exists {
+		return nil
+	}
+	return ph.remove(slotDeletions, rs.name, func() (err error) {
+		dbName, err := ph.conn.runQueryGetOneField(
+			"SELECT COALESCE(database, '') FROM pg_replication_slots WHERE slot_name = $1", rs.name)
+		if err != nil {
+			return err
+		}
+		err = rs.slotConn(dbName).runQueryExec("SELECT pg_drop_replication_slot($1)", rs.name)
 		if err != nil {
 			return err
 		}
 		log.Infof("Replication slot '%s' succesfully dropped", rs.name)
-	}
-	return nil
+		return nil
+	})
 }
 
 func (rs ReplicationSlot) Create() (err error) {
+	err = rs.validate()
+	if err != nil {
+		return err
+	}
 	conn := rs.handler.conn
 
 	exists, err := conn.runQueryExists("SELECT slot_name FROM pg_replication_slots WHERE slot_name = $1", rs.name)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		err = conn.runQueryExec("SELECT pg_create_physical_replication_slot($1)", rs.name)
+	if exists {
+		return rs.checkDrift()
+	}
+	if rs.Type == PhysicalSlot {
+		err = conn.runQueryExec("SELECT pg_create_physical_replication_slot($1, $2)", rs.name, rs.ReserveWal)
 		if err != nil {
 			return err
 		}
-		log.Infof("Created replication slot '%s'", rs.name)
+		log.Infof("Created physical replication slot '%s'", rs.name)
+		return nil
+	}
+	err = rs.createLogical()
+	if err != nil {
+		return err
+	}
+	log.Infof("Created logical replication slot '%s' in db '%s' with plugin '%s'", rs.name, rs.Database, rs.Plugin)
+	return nil
+}
+
+func (rs ReplicationSlot) createLogical() (err error) {
+	c := rs.slotConn(rs.Database)
+	version, err := c.ServerVersionNum()
+	if err != nil {
+		return err
+	}
+	twoPhase := rs.TwoPhase
+	if twoPhase && version < 140000 {
+		log.Warnf("two_phase is not supported for replication slot '%s' on this server version, ignoring", rs.name)
+		twoPhase = false
+	}
+	if rs.Failover && version < 170000 {
+		log.Warnf("failover is not supported for replication slot '%s' on this server version, ignoring", rs.name)
+	}
+	switch {
+	case version >= 170000:
+		return c.runQueryExec("SELECT pg_create_logical_replication_slot($1, $2, false, $3, $4)", rs.name,
+			rs.Plugin, twoPhase, rs.Failover)
+	case version >= 140000:
+		return c.runQueryExec("SELECT pg_create_logical_replication_slot($1, $2, false, $3)", rs.name, rs.Plugin,
+			twoPhase)
+	default:
+		return c.runQueryExec("SELECT pg_create_logical_replication_slot($1, $2)", rs.name, rs.Plugin)
+	}
+}
+
+// driftQuery returns the query (and its arguments) which finds the slot when it is as configured. two_phase and
+// failover are only compared on server versions that report them, and reserve_wal only applies at creation.
+func (rs ReplicationSlot) driftQuery(version int) (qry string, args []interface{}) {
+	qry = `SELECT slot_name FROM pg_replication_slots WHERE slot_name = $1 AND slot_type = $2
+		AND COALESCE(plugin, '') = $3 AND COALESCE(database, '') = $4`
+	args = []interface{}{rs.name, string(rs.Type), rs.Plugin, rs.Database}
+	if rs.Type != LogicalSlot {
+		return qry, args
+	}
+	if version >= 140000 {
+		args = append(args, rs.TwoPhase)
+		qry += fmt.Sprintf(" AND two_phase = $%d", len(args))
+	}
+	if version >= 170000 {
+		args = append(args, rs.Failover)
+		qry += fmt.Sprintf(" AND failover = $%d", len(args))
+	}
+	return qry, args
+}
+
+// checkDrift reports when an existing slot has another type, plugin, database, two_phase or failover than configured
+func (rs ReplicationSlot) checkDrift() (err error) {
+	conn := rs.handler.conn
+	version, err := conn.ServerVersionNum()
+	if err != nil {
+		return err
+	}
+	qry, args := rs.driftQuery(version)
+	exists, err := conn.runQueryExists(qry, args...)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		log.Warnf("Replication slot '%s' exists, but differs from config (type: %s, plugin: '%s', database: '%s', "+
+			"two_phase: %t, failover: %t)", rs.name, rs.Type, rs.Plugin, rs.Database, rs.TwoPhase, rs.Failover)
 	}
 	return nil
 }
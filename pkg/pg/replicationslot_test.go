@@ -0,0 +1,76 @@
+package pg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplicationSlotValidate(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		slot  ReplicationSlot
+		fails bool
+	}{
+		{name: "physical", slot: ReplicationSlot{Type: PhysicalSlot, ReserveWal: true}},
+		{name: "logical", slot: ReplicationSlot{Type: LogicalSlot, Plugin: "pgoutput", TwoPhase: true, Failover: true}},
+		{name: "temporary", slot: ReplicationSlot{Type: PhysicalSlot, Temporary: true}, fails: true},
+		{name: "temporary logical", slot: ReplicationSlot{Type: LogicalSlot, Temporary: true}, fails: true},
+		{name: "logical with reserve_wal", slot: ReplicationSlot{Type: LogicalSlot, ReserveWal: true}, fails: true},
+		{name: "physical with plugin", slot: ReplicationSlot{Type: PhysicalSlot, Plugin: "pgoutput"}, fails: true},
+		{name: "physical with failover", slot: ReplicationSlot{Type: PhysicalSlot, Failover: true}, fails: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.slot.validate()
+			if failed := err != nil; failed != test.fails {
+				t.Errorf("expected fails to be %t, got %v", test.fails, err)
+			}
+		})
+	}
+}
+
+func TestReplicationSlotDriftQuery(t *testing.T) {
+	logical := ReplicationSlot{name: "cdc", Type: LogicalSlot, Plugin: "pgoutput", Database: "fga", TwoPhase: true}
+	physical := ReplicationSlot{name: "replica", Type: PhysicalSlot}
+	for _, test := range []struct {
+		name       string
+		slot       ReplicationSlot
+		version    int
+		conditions []string
+		args       []interface{}
+	}{
+		{name: "physical", slot: physical, version: 170000, args: []interface{}{"replica", "physical", "", ""}},
+		{name: "logical on 13", slot: logical, version: 130000, args: []interface{}{"cdc", "logical", "pgoutput", "fga"}},
+		{
+			name:       "logical on 14",
+			slot:       logical,
+			version:    140000,
+			conditions: []string{"two_phase = $5"},
+			args:       []interface{}{"cdc", "logical", "pgoutput", "fga", true},
+		},
+		{
+			name:       "logical on 17",
+			slot:       logical,
+			version:    170000,
+			conditions: []string{"two_phase = $5", "failover = $6"},
+			args:       []interface{}{"cdc", "logical", "pgoutput", "fga", true, false},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			qry, args := test.slot.driftQuery(test.version)
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("expected arguments %v, got %v", test.args, args)
+			}
+			for _, condition := range []string{"two_phase", "failover"} {
+				if strings.Contains(qry, condition) != strings.Contains(strings.Join(test.conditions, " "), condition) {
+					t.Errorf("unexpected conditions in %s", qry)
+				}
+			}
+			for _, condition := range test.conditions {
+				if !strings.Contains(qry, condition) {
+					t.Errorf("expected %s in %s", condition, qry)
+				}
+			}
+		})
+	}
+}
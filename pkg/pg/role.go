@@ -1,14 +1,13 @@
 package pg
 
 import (
-	"context"
 	"time"
 
 	// md5 is weak, but it is still an accepted password algorithm in Postgres.
 	// #nosec
 	"crypto/md5"
 	"fmt"
-	"github.com/jackc/pgx/v4"
+	"regexp"
 	"strings"
 )
 
@@ -30,15 +29,29 @@ func NewRole(handler *Handler, name string, options RoleOptions, state State) (r
 					role.State.String(), name)
 			}
 		}
+		// options (and the options of the role) can be shared (e.a. NoLoginOptions), so the merge is a copy
+		role.options = role.options.clone()
 		for _, option := range options {
+			if current, exists := role.options[option.name]; exists && current == option {
+				continue
+			}
 			role.options[option.name] = option
+			if !role.State.Bool() {
+				continue
+			}
+			// The role was created with other options (e.g. for a user in two ldap groups), so apply this one now
+			err = role.setRoleOption(option)
+			if err != nil {
+				return r, err
+			}
 		}
+		handler.roles[name] = role
 		return &role, nil
 	}
 	r = &Role{
 		handler: handler,
 		name:    name,
-		options: options,
+		options: options.clone(),
 		State:   state,
 	}
 	if state.Bool() {
@@ -69,30 +82,44 @@ func (r *Role) Drop() (err error) {
 		delete(r.handler.roles, r.name)
 		return nil
 	}
-	var dbname string
-	var newOwner string
-	query := `select db.datname, o.rolname as newOwner from pg_database db inner join 
-			  pg_roles o on db.datdba = o.oid where db.datname != 'template0'`
-	row := c.conn.QueryRow(context.Background(), query)
-	for {
-		scanErr := row.Scan(&dbname, &newOwner)
-		if scanErr == pgx.ErrNoRows {
-			break
-		} else if scanErr != nil {
-			return fmt.Errorf("error getting ReadOnly grants (qry: %s, err %s)", query, err)
+	err = ph.remove(roleDeletions, r.name, r.reassignAndDrop)
+	if err != nil {
+		return err
+	}
+	r.State = Absent
+	return nil
+}
+
+// reassignAndDrop reassigns all objects owned by the role to the owners of the databases, and drops the role
+func (r *Role) reassignAndDrop() (err error) {
+	ph := r.handler
+	c := ph.conn
+	dbNames, err := c.runQueryGetOneColumn("SELECT datname FROM pg_database WHERE datallowconn")
+	if err != nil {
+		return err
+	}
+	for _, dbName := range dbNames {
+		newOwner, err := c.runQueryGetOneField(
+			"SELECT pg_get_userbyid(datdba) FROM pg_database WHERE datname = $1", dbName)
+		if err != nil {
+			return err
 		}
-		dbConn := ph.GetDb(dbname).GetDbConnection()
+		dbConn := ph.getDbConnection(dbName)
 		err = dbConn.runQueryExec(fmt.Sprintf("REASSIGN OWNED BY %s TO %s", identifier(r.name), identifier(newOwner)))
 		if err != nil {
 			return err
 		}
-		log.Debugf("Reassigned ownership from '%s' to '%s' in db '%s'", r.name, newOwner, dbname)
+		// Objects are reassigned, so this only revokes the remaining privileges of the role
+		err = dbConn.runQueryExec(fmt.Sprintf("DROP OWNED BY %s", identifier(r.name)))
+		if err != nil {
+			return err
+		}
+		log.Debugf("Reassigned ownership from '%s' to '%s' in db '%s'", r.name, newOwner, dbName)
 	}
 	err = c.runQueryExec(fmt.Sprintf("DROP ROLE %s", identifier(r.name)))
 	if err != nil {
 		return err
 	}
-	r.State = Absent
 	log.Infof("Role '%s' succesfully dropped", r.name)
 	return nil
 }
@@ -170,14 +197,17 @@ func (r Role) RevokeRole(roleName string) (err error) {
 	if err != nil {
 		return err
 	}
-	if exists {
+	if !exists {
+		return nil
+	}
+	return r.handler.remove(membershipDeletions, fmt.Sprintf("%s of %s", roleName, r.name), func() (err error) {
 		err = c.runQueryExec(fmt.Sprintf("REVOKE %s FROM %s", identifier(roleName), identifier(r.name)))
 		if err != nil {
 			return err
 		}
 		log.Infof("Role '%s' succesfully revoked from user '%s'", roleName, r.name)
-	}
-	return nil
+		return nil
+	})
 }
 
 func (r Role) SetPassword(password string) (err error) {
@@ -251,6 +281,75 @@ func (r Role) SetExpiry(expiry time.Time) (err error) {
 	return nil
 }
 
+var validSettingName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// SetSetting sets the default value of a configuration parameter for the role (ALTER ROLE ... SET)
+func (r Role) SetSetting(name string, value string) (err error) {
+	if !validSettingName.MatchString(name) {
+		return fmt.Errorf("invalid setting name %s for role %s", name, r.name)
+	}
+	name = strings.ToLower(name)
+	c := r.handler.conn
+	checkQry := `SELECT rolname FROM pg_roles r WHERE rolname = $1 AND NOT EXISTS (SELECT 1 FROM pg_db_role_setting s
+		WHERE s.setrole = r.oid AND s.setdatabase = 0 AND $2 = ANY(s.setconfig))`
+	exists, err := c.runQueryExists(checkQry, r.name, name+"="+value)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	err = c.runQueryExec(fmt.Sprintf("ALTER ROLE %s SET %s = %s", identifier(r.name), name, quotedSqlValue(value)))
+	if err != nil {
+		return err
+	}
+	log.Infof("Succesfully set %s to %s for role '%s'", name, value, r.name)
+	return nil
+}
+
+// SetConnectionLimit sets the connection limit of the role (-1 means no limit)
+func (r Role) SetConnectionLimit(limit int) (err error) {
+	c := r.handler.conn
+	checkQry := "SELECT rolname FROM pg_roles WHERE rolname = $1 AND rolconnlimit != $2"
+	exists, err := c.runQueryExists(checkQry, r.name, limit)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	err = c.runQueryExec(fmt.Sprintf("ALTER ROLE %s CONNECTION LIMIT %d", identifier(r.name), limit))
+	if err != nil {
+		return err
+	}
+	log.Infof("Succesfully set connection limit %d for role '%s'", limit, r.name)
+	return nil
+}
+
+// SetComment sets the comment of the role, or removes it when empty
+func (r Role) SetComment(comment string) (err error) {
+	c := r.handler.conn
+	checkQry := `SELECT rolname FROM pg_roles WHERE rolname = $1
+		AND COALESCE(shobj_description(oid, 'pg_authid'), '') != $2`
+	exists, err := c.runQueryExists(checkQry, r.name, comment)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	value := "NULL"
+	if comment != "" {
+		value = quotedSqlValue(comment)
+	}
+	err = c.runQueryExec(fmt.Sprintf("COMMENT ON ROLE %s IS %s", identifier(r.name), value))
+	if err != nil {
+		return err
+	}
+	log.Infof("Succesfully set comment for role '%s'", r.name)
+	return nil
+}
+
 func (r Role) ResetExpiry() (err error) {
 	c := r.handler.conn
 	checkQry := `SELECT rolname FROM pg_roles where rolname = $1 AND rolvaliduntil IS NOT NULL AND rolvaliduntil != 'infinity';`
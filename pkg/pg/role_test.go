@@ -0,0 +1,24 @@
+package pg
+
+import "testing"
+
+func TestNewRoleKeepsSharedOptions(t *testing.T) {
+	ph := &Handler{roles: make(Roles)}
+	if _, err := NewRole(ph, "dba", NoLoginOptions, Absent); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inherit, err := NewRoleOption("NOINHERIT")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	role, err := NewRole(ph, "dba", RoleOptions{inherit.name: inherit}, Absent)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, exists := role.options[inherit.name]; !exists || len(role.options) != 2 {
+		t.Errorf("expected the options to be merged, got %v", role.options)
+	}
+	if len(NoLoginOptions) != 1 {
+		t.Errorf("expected NoLoginOptions to be left unchanged, got %v", NoLoginOptions)
+	}
+}
@@ -19,7 +19,7 @@ func NewRoleOption(name string) (opt RoleOption, err error) {
 	} else {
 		opt.enabled = true
 	}
-	if sql, exists := ValidRoleOptions[name]; exists {
+	if sql, exists := ValidRoleOptions[opt.name]; exists {
 		opt.sql = sql
 		return opt, nil
 	}
@@ -97,6 +97,15 @@ func (ro RoleOptions) AddOption(opt RoleOption) {
 	ro[opt.name] = opt
 }
 
+// clone returns a copy of the role options
+func (ro RoleOptions) clone() (clone RoleOptions) {
+	clone = make(RoleOptions)
+	for name, opt := range ro {
+		clone[name] = opt
+	}
+	return clone
+}
+
 //func (ros RoleOptions)Join(sep string) (joined string) {
 //	var strOptions []string
 //	for _, option := range ros {
@@ -108,5 +117,6 @@ func (ro RoleOptions) AddOption(opt RoleOption) {
 var (
 	LoginOption, _ = NewRoleOption("LOGIN")
 	LoginOptions   = RoleOptions{LoginOption.name: LoginOption}
+	NoLoginOptions = RoleOptions{LoginOption.name: LoginOption.Inverse()}
 	//EmptyOptions RoleOptions
 )
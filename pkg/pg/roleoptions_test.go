@@ -0,0 +1,33 @@
+package pg
+
+import "testing"
+
+func TestNewRoleOption(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		expected RoleOption
+		fails    bool
+	}{
+		{name: "LOGIN", expected: RoleOption{name: "LOGIN", sql: "rolcanlogin", enabled: true}},
+		{name: "login", expected: RoleOption{name: "LOGIN", sql: "rolcanlogin", enabled: true}},
+		{name: "NOLOGIN", expected: RoleOption{name: "LOGIN", sql: "rolcanlogin", enabled: false}},
+		{name: "noinherit", expected: RoleOption{name: "INHERIT", sql: "rolinherit", enabled: false}},
+		{name: "NOSUCHOPTION", fails: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opt, err := NewRoleOption(test.name)
+			if test.fails {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, opt)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SlotHealthOptions configures monitoring of replication slots and the optional drop of slots that retain too much WAL
+type SlotHealthOptions struct {
+	WarnRetainedBytes ByteSize      `yaml:"warn_retained_bytes"`
+	AutoDrop          bool          `yaml:"auto_drop"`
+	MaxRetainedBytes  ByteSize      `yaml:"max_retained_bytes"`
+	GracePeriod       time.Duration `yaml:"grace_period"`
+}
+
+// validate requires a grace period for auto_drop, so that a slot is never dropped on the first run that sees it
+func (options SlotHealthOptions) validate() (err error) {
+	if options.AutoDrop && options.GracePeriod <= 0 {
+		return fmt.Errorf("replication_slot_health grace_period should be set for auto_drop")
+	}
+	return nil
+}
+
+type slotHealth struct {
+	name          string
+	active        bool
+	retainedBytes sql.NullInt64
+	inactiveFor   sql.NullFloat64
+}
+
+func (ph *Handler) getSlotHealth() (slots []slotHealth, err error) {
+	c := ph.conn
+	version, err := c.ServerVersionNum()
+	if err != nil {
+		return nil, err
+	}
+	// inactive_since is only available from PostgreSQL 17 onwards
+	inactiveFor := "NULL::float8"
+	if version >= 170000 {
+		inactiveFor = "EXTRACT(EPOCH FROM now() - inactive_since)::float8"
+	}
+	query := fmt.Sprintf(`SELECT slot_name, active,
+		pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END,
+		restart_lsn)::bigint, %s
+		FROM pg_replication_slots`, inactiveFor)
+	err = c.Connect()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.conn.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("error getting replication slot health (qry: %s, err %s)", query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slot slotHealth
+		err = rows.Scan(&slot.name, &slot.active, &slot.retainedBytes, &slot.inactiveFor)
+		if err != nil {
+			return nil, fmt.Errorf("error getting replication slot health (qry: %s, err %s)", query, err)
+		}
+		slots = append(slots, slot)
+	}
+	return slots, rows.Err()
+}
+
+// CheckSlotHealth warns about replication slots retaining more WAL than configured, and (when configured) drops
+// managed slots which are inactive and over the retention limit for longer than the grace period.
+func (ph *Handler) CheckSlotHealth() (err error) {
+	options := ph.slotHealthOptions
+	if options.WarnRetainedBytes == 0 && !options.AutoDrop {
+		return nil
+	}
+	err = options.validate()
+	if err != nil {
+		return err
+	}
+	slots, err := ph.getSlotHealth()
+	if err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		if !slot.retainedBytes.Valid {
+			continue
+		}
+		retained := ByteSize(slot.retainedBytes.Int64)
+		if options.WarnRetainedBytes > 0 && retained > options.WarnRetainedBytes {
+			log.Warnf("Replication slot '%s' (active: %t) retains %s of WAL (threshold: %s)", slot.name, slot.active,
+				retained, options.WarnRetainedBytes)
+		}
+		if !options.AutoDrop || slot.active || options.MaxRetainedBytes == 0 || retained <= options.MaxRetainedBytes {
+			continue
+		}
+		rs, managed := ph.slots[slot.name]
+		if !managed {
+			log.Debugf("Not dropping replication slot '%s' over retention limit (not managed by pgfga)", slot.name)
+			continue
+		}
+		if !slot.inactiveFor.Valid {
+			log.Warnf("Not dropping replication slot '%s' over retention limit (cannot determine how long it has "+
+				"been inactive, which requires PostgreSQL 17 or newer)", slot.name)
+			continue
+		}
+		inactiveFor := time.Duration(slot.inactiveFor.Float64 * float64(time.Second))
+		if inactiveFor < options.GracePeriod {
+			log.Warnf("Replication slot '%s' is over retention limit, but only inactive for %s (grace period: %s)",
+				slot.name, inactiveFor.Round(time.Second), options.GracePeriod)
+			continue
+		}
+		log.Warnf("Dropping replication slot '%s', which retains %s of WAL and is inactive for %s", slot.name,
+			retained, inactiveFor.Round(time.Second))
+		err = rs.Drop()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package pg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlotHealthOptionsValidate(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		options SlotHealthOptions
+		fails   bool
+	}{
+		{name: "warn only", options: SlotHealthOptions{WarnRetainedBytes: 5 << 30}},
+		{name: "auto_drop", options: SlotHealthOptions{AutoDrop: true, MaxRetainedBytes: 50 << 30,
+			GracePeriod: 24 * time.Hour}},
+		{name: "auto_drop without grace_period", options: SlotHealthOptions{AutoDrop: true,
+			MaxRetainedBytes: 50 << 30}, fails: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.options.validate()
+			if failed := err != nil; failed != test.fails {
+				t.Errorf("expected fails to be %t, got %v", test.fails, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package pg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pgvillage-tools/pgfga/pkg/credential"
+)
+
+type Subscriptions map[string]*Subscription
+
+type Subscription struct {
+	// name and db are set by the database
+	db           *Database
+	name         string
+	ConnInfo     credential.Credential `yaml:"conninfo"`
+	Publications []string              `yaml:"publications"`
+	Enabled      *bool                 `yaml:"enabled"`
+	SlotName     string                `yaml:"slot_name"`
+	State        State                 `yaml:"state"`
+}
+
+// SetDefaults is called to set all defaults for subscriptions created from yaml
+func (s *Subscription) SetDefaults() {
+	if s.Enabled == nil {
+		enabled := true
+		s.Enabled = &enabled
+	}
+}
+
+func (s Subscription) publicationList() (publications string) {
+	var names []string
+	for _, name := range s.Publications {
+		names = append(names, identifier(name))
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *Subscription) Drop() (err error) {
+	if !s.db.handler.strictOptions.Subscriptions {
+		log.Infof("not dropping subscription '%s'.'%s' (config.strict.subscriptions is not True)", s.db.name, s.name)
+		return nil
+	}
+	c := s.db.GetDbConnection()
+	exists, err := c.runQueryExists(`SELECT subname FROM pg_subscription
+		WHERE subname = $1 AND subdbid = (SELECT oid FROM pg_database WHERE datname = current_database())`, s.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	err = s.db.handler.remove(subscriptionDeletions, s.db.name+"."+s.name, func() (err error) {
+		err = c.runQueryExec("DROP SUBSCRIPTION " + identifier(s.name))
+		if err != nil {
+			return err
+		}
+		log.Infof("Subscription '%s'.'%s' succesfully dropped.", s.db.name, s.name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.State = Absent
+	return nil
+}
+
+func (s Subscription) Create() (err error) {
+	if len(s.Publications) == 0 {
+		return fmt.Errorf("subscription %s should have at least one publication", s.name)
+	}
+	connInfo, err := s.ConnInfo.GetCred()
+	if err != nil {
+		return fmt.Errorf("could not get conninfo for subscription %s: %v", s.name, err)
+	}
+	connInfo = strings.TrimSpace(connInfo)
+	c := s.db.GetDbConnection()
+	exists, err := c.runQueryExists(`SELECT subname FROM pg_subscription
+		WHERE subname = $1 AND subdbid = (SELECT oid FROM pg_database WHERE datname = current_database())`, s.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		createQry := fmt.Sprintf("CREATE SUBSCRIPTION %s CONNECTION %s PUBLICATION %s WITH (enabled = %t",
+			identifier(s.name), quotedSqlValue(connInfo), s.publicationList(), *s.Enabled)
+		if s.SlotName != "" {
+			createQry += ", slot_name = " + quotedSqlValue(s.SlotName)
+		}
+		err = c.runQueryExec(createQry + ")")
+		if err != nil {
+			return err
+		}
+		log.Infof("Subscription '%s'.'%s' succesfully created.", s.db.name, s.name)
+		return nil
+	}
+	exists, err = c.runQueryExists("SELECT subname FROM pg_subscription WHERE subname = $1 AND subconninfo = $2",
+		s.name, connInfo)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = c.runQueryExec(fmt.Sprintf("ALTER SUBSCRIPTION %s CONNECTION %s", identifier(s.name),
+			quotedSqlValue(connInfo)))
+		if err != nil {
+			return err
+		}
+		log.Infof("Subscription '%s'.'%s' succesfully altered with new conninfo", s.db.name, s.name)
+	}
+	publications := append([]string{}, s.Publications...)
+	sort.Strings(publications)
+	current, err := c.runQueryGetOneField(`SELECT array_to_string(ARRAY(SELECT unnest(subpublication) ORDER BY 1), ',')
+		FROM pg_subscription WHERE subname = $1`, s.name)
+	if err != nil {
+		return err
+	}
+	if current != strings.Join(publications, ",") {
+		err = c.runQueryExec(fmt.Sprintf("ALTER SUBSCRIPTION %s SET PUBLICATION %s", identifier(s.name),
+			s.publicationList()))
+		if err != nil {
+			return err
+		}
+		log.Infof("Subscription '%s'.'%s' succesfully altered with publications %s", s.db.name, s.name,
+			strings.Join(s.Publications, ", "))
+	}
+	exists, err = c.runQueryExists("SELECT subname FROM pg_subscription WHERE subname = $1 AND subenabled = $2",
+		s.name, *s.Enabled)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		action := "DISABLE"
+		if *s.Enabled {
+			action = "ENABLE"
+		}
+		err = c.runQueryExec(fmt.Sprintf("ALTER SUBSCRIPTION %s %s", identifier(s.name), action))
+		if err != nil {
+			return err
+		}
+		log.Infof("Subscription '%s'.'%s' succesfully altered (%s)", s.db.name, s.name, strings.ToLower(action))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package pg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Tablespaces map[string]*Tablespace
+
+type Tablespace struct {
+	// for tablespaces created from yaml, handler and name are set by the pg.Handler
+	handler  *Handler
+	name     string
+	Location string            `yaml:"location"`
+	Owner    string            `yaml:"owner"`
+	Options  map[string]string `yaml:"options"`
+	State    State             `yaml:"state"`
+}
+
+var validTablespaceOptions = map[string]bool{
+	"seq_page_cost":              true,
+	"random_page_cost":           true,
+	"effective_io_concurrency":   true,
+	"maintenance_io_concurrency": true,
+}
+
+func (ts Tablespace) validate() (err error) {
+	if ts.Location == "" {
+		return fmt.Errorf("location must be set for tablespace %s", ts.name)
+	}
+	for option := range ts.Options {
+		if !validTablespaceOptions[option] {
+			var validOptions []string
+			for name := range validTablespaceOptions {
+				validOptions = append(validOptions, name)
+			}
+			sort.Strings(validOptions)
+			return fmt.Errorf("invalid option %s for tablespace %s (should be one of %s)", option, ts.name,
+				strings.Join(validOptions, ", "))
+		}
+	}
+	return nil
+}
+
+func (ts *Tablespace) Drop() (err error) {
+	ph := ts.handler
+	if !ph.strictOptions.Tablespaces {
+		log.Infof("skipping drop of tablespace %s (not running with strict option for tablespaces", ts.name)
+		return nil
+	}
+	exists, err := ph.conn.runQueryExists("SELECT spcname FROM pg_tablespace WHERE spcname = $1", ts.name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = ph.remove(tablespaceDeletions, ts.name, func() (err error) {
+			err = ph.conn.runQueryExec(fmt.Sprintf("DROP TABLESPACE %s", identifier(ts.name)))
+			if err != nil {
+				return err
+			}
+			log.Infof("Tablespace '%s' succesfully dropped", ts.name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	ts.State = Absent
+	return nil
+}
+
+func (ts Tablespace) Create() (err error) {
+	err = ts.validate()
+	if err != nil {
+		return err
+	}
+	ph := ts.handler
+	if ts.Owner != "" {
+		// First make sure role exists
+		_, err = ph.GetRole(ts.Owner)
+		if err != nil {
+			return err
+		}
+	}
+	exists, err := ph.conn.runQueryExists("SELECT spcname FROM pg_tablespace WHERE spcname = $1", ts.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		createQry := fmt.Sprintf("CREATE TABLESPACE %s", identifier(ts.name))
+		if ts.Owner != "" {
+			createQry += " OWNER " + identifier(ts.Owner)
+		}
+		createQry += " LOCATION " + quotedSqlValue(ts.Location)
+		err = ph.conn.runQueryExec(createQry)
+		if err != nil {
+			return err
+		}
+		log.Infof("Tablespace '%s' succesfully created", ts.name)
+	} else {
+		exists, err = ph.conn.runQueryExists(`SELECT spcname FROM pg_tablespace
+			WHERE spcname = $1 AND pg_tablespace_location(oid) = $2`, ts.name, ts.Location)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Warnf("Tablespace '%s' exists, but with another location than '%s'", ts.name, ts.Location)
+		}
+	}
+	if ts.Owner != "" {
+		exists, err = ph.conn.runQueryExists(`SELECT spcname FROM pg_tablespace spc INNER JOIN pg_roles rol
+			ON spc.spcowner = rol.oid WHERE spcname = $1 AND rolname = $2`, ts.name, ts.Owner)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			err = ph.conn.runQueryExec(fmt.Sprintf("ALTER TABLESPACE %s OWNER TO %s", identifier(ts.name),
+				identifier(ts.Owner)))
+			if err != nil {
+				return err
+			}
+			log.Infof("Tablespace owner succesfully altered to '%s' on '%s'", ts.Owner, ts.name)
+		}
+	}
+	return ts.setOptions()
+}
+
+func (ts Tablespace) setOptions() (err error) {
+	c := ts.handler.conn
+	current, err := c.runQueryGetOneColumn(`SELECT unnest(spcoptions) FROM pg_tablespace WHERE spcname = $1`,
+		ts.name)
+	if err != nil {
+		return err
+	}
+	var wanted []string
+	for option, value := range ts.Options {
+		wanted = append(wanted, fmt.Sprintf("%s=%s", option, value))
+	}
+	missing, extra := listDiff(wanted, current)
+	for _, option := range extra {
+		name := strings.SplitN(option, "=", 2)[0]
+		if _, configured := ts.Options[name]; configured {
+			// Will be set to the configured value below
+			continue
+		}
+		err = c.runQueryExec(fmt.Sprintf("ALTER TABLESPACE %s RESET (%s)", identifier(ts.name), name))
+		if err != nil {
+			return err
+		}
+		log.Infof("Option '%s' succesfully reset on tablespace '%s'", name, ts.name)
+	}
+	for _, option := range missing {
+		name := strings.SplitN(option, "=", 2)[0]
+		err = c.runQueryExec(fmt.Sprintf("ALTER TABLESPACE %s SET (%s = %s)", identifier(ts.name), name,
+			quotedSqlValue(ts.Options[name])))
+		if err != nil {
+			return err
+		}
+		log.Infof("Option '%s' succesfully set to '%s' on tablespace '%s'", name, ts.Options[name], ts.name)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package pg
+
+import "testing"
+
+func TestTablespaceValidate(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		tablespace Tablespace
+		fails      bool
+	}{
+		{name: "location", tablespace: Tablespace{Location: "/data/fast"}},
+		{
+			name:       "options",
+			tablespace: Tablespace{Location: "/data/fast", Options: map[string]string{"random_page_cost": "1.1"}},
+		},
+		{name: "without location", tablespace: Tablespace{}, fails: true},
+		{
+			name:       "invalid option",
+			tablespace: Tablespace{Location: "/data/fast", Options: map[string]string{"fillfactor": "90"}},
+			fails:      true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.tablespace.validate()
+			if failed := err != nil; failed != test.fails {
+				t.Errorf("expected fails to be %t, got %v", test.fails, err)
+			}
+		})
+	}
+}
+
+func TestDatabaseCheckTablespace(t *testing.T) {
+	ph := &Handler{tablespaces: Tablespaces{
+		"fast": {Location: "/data/fast", State: Present},
+		"old":  {Location: "/data/old", State: Absent},
+	}}
+	for _, test := range []struct {
+		tablespace string
+		fails      bool
+	}{
+		{tablespace: ""},
+		{tablespace: "fast"},
+		// Tablespaces that are not managed by pgfga
+		{tablespace: "pg_default"},
+		{tablespace: "old", fails: true},
+	} {
+		t.Run(test.tablespace, func(t *testing.T) {
+			d := Database{handler: ph, name: "app", Tablespace: test.tablespace}
+			err := d.checkTablespace()
+			if failed := err != nil; failed != test.fails {
+				t.Errorf("expected fails to be %t, got %v", test.fails, err)
+			}
+		})
+	}
+}